@@ -11,9 +11,33 @@ func RespondSuccess(c *gin.Context, status int, data interface{}) {
 	})
 }
 
+func RespondSuccessPaginado(c *gin.Context, status int, data interface{}, meta interface{}) {
+	c.JSON(status, gin.H{
+		"success": true,
+		"data":    data,
+		"meta":    meta,
+	})
+}
+
 func RespondError(c *gin.Context, status int, message string) {
 	c.JSON(status, gin.H{
 		"success": false,
 		"error":   message,
 	})
-}
\ No newline at end of file
+}
+
+func RespondErrorData(c *gin.Context, status int, message string, data interface{}) {
+	c.JSON(status, gin.H{
+		"success": false,
+		"error":   message,
+		"data":    data,
+	})
+}
+
+func RespondErrorCodigo(c *gin.Context, status int, codigo string, message string) {
+	c.JSON(status, gin.H{
+		"success": false,
+		"error":   message,
+		"codigo":  codigo,
+	})
+}
@@ -0,0 +1,107 @@
+package canales
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// Nombres de los canales de entrega
+const (
+	Email    = "email"
+	SMS      = "sms"
+	WhatsApp = "whatsapp"
+	Webhook  = "webhook"
+)
+
+// Destinatario es el usuario al que va dirigida una notificación
+type Destinatario struct {
+	UsuarioID uint
+	Nombre    string
+	Correo    string
+	Telefono  string
+}
+
+// Mensaje es el contenido de una notificación a entregar. Clave identifica la entrega y se repite
+// en los reintentos, para que el receptor pueda descartar duplicados.
+type Mensaje struct {
+	Clave          string    `json:"clave"`
+	NotificacionID uint      `json:"notificacion_id"`
+	UsuarioID      uint      `json:"usuario_id"`
+	CitaID         uint      `json:"cita_id"`
+	Tipo           string    `json:"tipo"`
+	Texto          string    `json:"mensaje"`
+	Fecha          time.Time `json:"fecha"`
+}
+
+// Canal entrega notificaciones por un medio externo
+type Canal interface {
+	// Nombre identifica el canal (email, sms, whatsapp, webhook)
+	Nombre() string
+	// Destino devuelve la dirección del destinatario en este canal, o "" si no tiene
+	Destino(d Destinatario) string
+	// Enviar entrega el mensaje a la dirección indicada
+	Enviar(destino string, m Mensaje) error
+}
+
+var (
+	mu          sync.RWMutex
+	registrados map[string]Canal
+)
+
+// Configurar reemplaza los canales en uso (por ejemplo, con canales falsos en pruebas)
+func Configurar(lista ...Canal) {
+	mu.Lock()
+	defer mu.Unlock()
+	registrados = map[string]Canal{}
+	for _, canal := range lista {
+		registrados[canal.Nombre()] = canal
+	}
+}
+
+// Registrados devuelve los canales configurados. Por defecto se leen de las variables de entorno
+// y solo se activan los que tienen su configuración completa.
+func Registrados() map[string]Canal {
+	mu.RLock()
+	actuales := registrados
+	mu.RUnlock()
+	if actuales != nil {
+		return actuales
+	}
+
+	Configurar(desdeEntorno()...)
+	return Registrados()
+}
+
+// desdeEntorno arma los canales a partir de SMTP_*, SMS_*, WHATSAPP_* y WEBHOOK_*
+func desdeEntorno() []Canal {
+	lista := []Canal{}
+
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		puerto := os.Getenv("SMTP_PUERTO")
+		if puerto == "" {
+			puerto = "587"
+		}
+		lista = append(lista, SMTP{
+			Host:      host,
+			Puerto:    puerto,
+			Usuario:   os.Getenv("SMTP_USUARIO"),
+			Clave:     os.Getenv("SMTP_CLAVE"),
+			Remitente: os.Getenv("SMTP_REMITENTE"),
+		})
+	}
+
+	if url := os.Getenv("SMS_URL"); url != "" {
+		lista = append(lista, ProveedorSMS{URL: url, Token: os.Getenv("SMS_TOKEN"), Remitente: os.Getenv("SMS_REMITENTE")})
+	}
+
+	if url := os.Getenv("WHATSAPP_URL"); url != "" {
+		lista = append(lista, ProveedorWhatsApp{URL: url, Token: os.Getenv("WHATSAPP_TOKEN")})
+	}
+
+	if url := os.Getenv("WEBHOOK_URL"); url != "" {
+		lista = append(lista, WebhookGenerico{URL: url, Secreto: os.Getenv("WEBHOOK_SECRETO")})
+	}
+
+	return lista
+}
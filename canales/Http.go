@@ -0,0 +1,140 @@
+package canales
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Cliente HTTP compartido por los canales que usan una API
+var clienteHTTP = &http.Client{Timeout: 15 * time.Second}
+
+// enviarJSON hace un POST con el cuerpo en JSON y considera error cualquier respuesta que no sea 2xx
+func enviarJSON(url string, cuerpo interface{}, encabezados map[string]string) error {
+	datos, err := json.Marshal(cuerpo)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(datos))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for nombre, valor := range encabezados {
+		req.Header.Set(nombre, valor)
+	}
+
+	resp, err := clienteHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detalle, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("respuesta %d: %s", resp.StatusCode, bytes.TrimSpace(detalle))
+	}
+	return nil
+}
+
+// autorizacion arma el encabezado Bearer si hay token
+func autorizacion(token string) map[string]string {
+	if token == "" {
+		return nil
+	}
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+// ProveedorSMS envía mensajes de texto a través de la API HTTP de un proveedor de SMS
+type ProveedorSMS struct {
+	URL       string
+	Token     string
+	Remitente string
+}
+
+func (p ProveedorSMS) Nombre() string {
+	return SMS
+}
+
+func (p ProveedorSMS) Destino(d Destinatario) string {
+	return d.Telefono
+}
+
+func (p ProveedorSMS) Enviar(destino string, m Mensaje) error {
+	cuerpo := map[string]string{"to": destino, "from": p.Remitente, "body": m.Texto}
+	if err := enviarJSON(p.URL, cuerpo, autorizacion(p.Token)); err != nil {
+		return fmt.Errorf("sms: %w", err)
+	}
+	return nil
+}
+
+// ProveedorWhatsApp envía mensajes con la API de WhatsApp Business (Cloud API). URL es el
+// endpoint de mensajes del número emisor.
+type ProveedorWhatsApp struct {
+	URL   string
+	Token string
+}
+
+func (p ProveedorWhatsApp) Nombre() string {
+	return WhatsApp
+}
+
+func (p ProveedorWhatsApp) Destino(d Destinatario) string {
+	return d.Telefono
+}
+
+func (p ProveedorWhatsApp) Enviar(destino string, m Mensaje) error {
+	cuerpo := map[string]interface{}{
+		"messaging_product": "whatsapp",
+		"to":                destino,
+		"type":              "text",
+		"text":              map[string]string{"body": m.Texto},
+	}
+	if err := enviarJSON(p.URL, cuerpo, autorizacion(p.Token)); err != nil {
+		return fmt.Errorf("whatsapp: %w", err)
+	}
+	return nil
+}
+
+// WebhookGenerico publica cada notificación en una URL para integraciones de la clínica. Si hay
+// secreto, el cuerpo se firma con HMAC-SHA256 en el encabezado X-CMedicas-Firma. La clave del
+// mensaje viaja en Idempotency-Key.
+type WebhookGenerico struct {
+	URL     string
+	Secreto string
+}
+
+func (w WebhookGenerico) Nombre() string {
+	return Webhook
+}
+
+// Destino es siempre la URL configurada: el webhook recibe las notificaciones de todos los usuarios
+func (w WebhookGenerico) Destino(d Destinatario) string {
+	return w.URL
+}
+
+func (w WebhookGenerico) Enviar(destino string, m Mensaje) error {
+	datos, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	encabezados := map[string]string{"Idempotency-Key": m.Clave}
+	if w.Secreto != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secreto))
+		mac.Write(datos)
+		encabezados["X-CMedicas-Firma"] = hex.EncodeToString(mac.Sum(nil))
+	}
+
+	if err := enviarJSON(destino, json.RawMessage(datos), encabezados); err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	return nil
+}
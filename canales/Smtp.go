@@ -0,0 +1,118 @@
+package canales
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Tiempo máximo para conectar y entregar un correo; un servidor que no responde no debe retener
+// al worker del outbox
+var esperaSMTP = 30 * time.Second
+
+// SMTP envía las notificaciones por correo electrónico
+type SMTP struct {
+	Host      string
+	Puerto    string
+	Usuario   string // Sin usuario se envía sin autenticación (p. ej. a un servidor local de pruebas)
+	Clave     string
+	Remitente string
+}
+
+func (s SMTP) Nombre() string {
+	return Email
+}
+
+func (s SMTP) Destino(d Destinatario) string {
+	return d.Correo
+}
+
+// Enviar arma un correo de texto plano en UTF-8 y lo entrega al servidor configurado
+func (s SMTP) Enviar(destino string, m Mensaje) error {
+	remitente := s.Remitente
+	if remitente == "" {
+		remitente = s.Usuario
+	}
+
+	var auth smtp.Auth
+	if s.Usuario != "" {
+		auth = smtp.PlainAuth("", s.Usuario, s.Clave, s.Host)
+	}
+
+	asunto := "CMedicas: " + m.Tipo
+	cuerpo := strings.Join([]string{
+		"From: " + remitente,
+		"To: " + destino,
+		"Subject: " + mime.QEncoding.Encode("utf-8", asunto),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: <" + m.Clave + "@cmedicas>",
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+		"",
+		m.Texto,
+	}, "\r\n")
+
+	if err := s.entregar(auth, remitente, destino, []byte(cuerpo)); err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+	return nil
+}
+
+// entregar hace lo mismo que smtp.SendMail, pero con un plazo para toda la conversación
+func (s SMTP) entregar(auth smtp.Auth, remitente, destino string, cuerpo []byte) error {
+	// Las direcciones van también en los encabezados: un salto de línea permitiría inyectar otros
+	if strings.ContainsAny(remitente+destino, "\r\n") {
+		return errors.New("dirección de correo inválida")
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(s.Host, s.Puerto), esperaSMTP)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(esperaSMTP)); err != nil {
+		conn.Close()
+		return err
+	}
+
+	cliente, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer cliente.Close()
+
+	if ok, _ := cliente.Extension("STARTTLS"); ok {
+		if err := cliente.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if err := cliente.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := cliente.Mail(remitente); err != nil {
+		return err
+	}
+	if err := cliente.Rcpt(destino); err != nil {
+		return err
+	}
+	w, err := cliente.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(cuerpo); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return cliente.Quit()
+}
@@ -0,0 +1,150 @@
+package canales
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// capturaSMTP es un servidor SMTP mínimo que acepta una conexión y guarda lo recibido
+type capturaSMTP struct {
+	listener net.Listener
+	comandos []string
+	datos    string
+	listo    chan struct{}
+}
+
+func nuevaCapturaSMTP(t *testing.T) *capturaSMTP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	captura := &capturaSMTP{listener: listener, listo: make(chan struct{})}
+	go captura.atender()
+	return captura
+}
+
+func (s *capturaSMTP) atender() {
+	defer close(s.listo)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	responder := func(linea string) { conn.Write([]byte(linea + "\r\n")) }
+	responder("220 captura ESMTP")
+	for {
+		linea, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		comando := strings.TrimRight(linea, "\r\n")
+		s.comandos = append(s.comandos, comando)
+
+		switch verbo := strings.ToUpper(strings.SplitN(comando, " ", 2)[0]); verbo {
+		case "EHLO", "HELO":
+			responder("250 captura")
+		case "DATA":
+			responder("354 fin con <CRLF>.<CRLF>")
+			var b strings.Builder
+			for {
+				linea, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if linea == ".\r\n" {
+					break
+				}
+				b.WriteString(linea)
+			}
+			s.datos = b.String()
+			responder("250 recibido")
+		case "QUIT":
+			responder("221 adiós")
+			return
+		default:
+			responder("250 ok")
+		}
+	}
+}
+
+func (s *capturaSMTP) puerto() string {
+	return strconv.Itoa(s.listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestSMTPEnviar(t *testing.T) {
+	captura := nuevaCapturaSMTP(t)
+	canal := SMTP{Host: "127.0.0.1", Puerto: captura.puerto(), Remitente: "citas@cmedicas.local"}
+
+	err := canal.Enviar("paciente@ejemplo.com", Mensaje{Clave: "entrega-7", Tipo: "recordatorio", Texto: "Tiene una cita mañana"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-captura.listo
+
+	esperados := []string{"MAIL FROM:<citas@cmedicas.local>", "RCPT TO:<paciente@ejemplo.com>", "DATA", "QUIT"}
+	for _, esperado := range esperados {
+		encontrado := false
+		for _, comando := range captura.comandos {
+			if strings.HasPrefix(comando, esperado) {
+				encontrado = true
+			}
+		}
+		if !encontrado {
+			t.Errorf("no se recibió %q; comandos: %v", esperado, captura.comandos)
+		}
+	}
+
+	for _, encabezado := range []string{"To: paciente@ejemplo.com", "Message-ID: <entrega-7@cmedicas>", "Content-Type: text/plain; charset=utf-8"} {
+		if !strings.Contains(captura.datos, encabezado+"\r\n") {
+			t.Errorf("falta el encabezado %q en:\n%s", encabezado, captura.datos)
+		}
+	}
+	if !strings.HasSuffix(captura.datos, "\r\n\r\nTiene una cita mañana\r\n") {
+		t.Errorf("cuerpo inesperado:\n%s", captura.datos)
+	}
+}
+
+func TestSMTPSinRespuesta(t *testing.T) {
+	// Un servidor que acepta la conexión y nunca saluda
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	anterior := esperaSMTP
+	esperaSMTP = 200 * time.Millisecond
+	defer func() { esperaSMTP = anterior }()
+
+	puerto := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	inicio := time.Now()
+	if err := (SMTP{Host: "127.0.0.1", Puerto: puerto}).Enviar("paciente@ejemplo.com", Mensaje{}); err == nil {
+		t.Fatal("se esperaba un error por tiempo de espera")
+	}
+	if transcurrido := time.Since(inicio); transcurrido > 2*time.Second {
+		t.Errorf("el envío tardó %v; no respetó el plazo", transcurrido)
+	}
+}
+
+func TestSMTPRechazaSaltosDeLinea(t *testing.T) {
+	err := (SMTP{Host: "127.0.0.1", Puerto: "1"}).Enviar("paciente@ejemplo.com\r\nBcc: otro@ejemplo.com", Mensaje{})
+	if err == nil || !strings.Contains(err.Error(), "inválida") {
+		t.Errorf("se esperaba rechazar la dirección, se obtuvo %v", err)
+	}
+}
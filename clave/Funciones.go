@@ -45,6 +45,27 @@ func GenerateJWT(userID uint, rol string) (string, error) {
 	// return token.SignedString(jwtSecret)
 }
 
+// Uso del ticket para abrir el stream de eventos
+const UsoStream = "stream"
+
+// Vigencia del ticket del stream: solo sirve para abrir la conexión
+const VigenciaTicketStream = time.Minute
+
+// Genera un ticket de corta duración que solo sirve para abrir el stream de eventos. Va en la URL
+// (EventSource no permite encabezados), así que puede quedar en los logs; por eso no es el token
+// de la sesión.
+func GenerateStreamTicket(userID uint, rol string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": userID,
+		"rol": rol,
+		"uso": UsoStream,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(VigenciaTicketStream).Unix(),
+	})
+
+	return token.SignedString(getJWTSecret())
+}
+
 // Validar token
 // func ValidateJWT(tokenString string) (*jwt.Token, error) {
 // 	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Vistas de agenda disponibles
+const (
+	vistaAgendaDia    = "dia"
+	vistaAgendaSemana = "semana"
+	vistaAgendaMes    = "mes"
+)
+
+// AgendaMedico es la agenda de un médico en un día: horario de atención, ausencias y citas
+type AgendaMedico struct {
+	MedicoID     uint                   `json:"medico_id"`
+	Nombre       string                 `json:"nombre"`
+	Especialidad string                 `json:"especialidad"`
+	Horarios     []Slot                 `json:"horarios"`
+	Bloqueos     []models.BloqueoAgenda `json:"bloqueos"`
+	Citas        []models.Cita          `json:"citas"`
+}
+
+// AgendaDia agrupa las agendas de los médicos que atienden o tienen citas en un día
+type AgendaDia struct {
+	Fecha   string             `json:"fecha"`
+	Festivo *models.DiaFestivo `json:"festivo"`
+	Medicos []AgendaMedico     `json:"medicos"`
+}
+
+// rangoVistaAgenda calcula el rango de días de la vista que contiene la fecha: el día mismo,
+// su semana de lunes a domingo o su mes
+func rangoVistaAgenda(vista string, fecha time.Time) (time.Time, time.Time) {
+	switch vista {
+	case vistaAgendaSemana:
+		desde := fecha.AddDate(0, 0, -((int(fecha.Weekday()) + 6) % 7))
+		return desde, desde.AddDate(0, 0, 6)
+	case vistaAgendaMes:
+		desde := time.Date(fecha.Year(), fecha.Month(), 1, 0, 0, 0, 0, time.Local)
+		return desde, desde.AddDate(0, 1, -1)
+	}
+	return fecha, fecha
+}
+
+// GetAgenda devuelve las citas de uno o varios médicos agrupadas por día y médico, junto con sus
+// horarios y ausencias, para pintar la agenda sin combinar varias consultas.
+// Parámetros: vista (dia, semana, mes), fecha (YYYY-MM-DD, hoy por defecto) o desde/hasta,
+// medico_id (lista separada por comas), especialidad e incluir_canceladas.
+// Recepción y administración ven a todos los médicos; un médico solo su propia agenda.
+func GetAgenda(c *gin.Context) {
+	db := initializers.GetDB()
+
+	vista := c.DefaultQuery("vista", vistaAgendaDia)
+	if vista != vistaAgendaDia && vista != vistaAgendaSemana && vista != vistaAgendaMes {
+		respuestas.RespondError(c, http.StatusBadRequest, "Vista inválida. Use dia, semana o mes")
+		return
+	}
+
+	hoy := time.Now()
+	fecha := time.Date(hoy.Year(), hoy.Month(), hoy.Day(), 0, 0, 0, 0, time.Local)
+	if valor := c.Query("fecha"); valor != "" {
+		var err error
+		fecha, err = time.ParseInLocation("2006-01-02", valor, time.Local)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Formato de fecha inválido. Use YYYY-MM-DD")
+			return
+		}
+	}
+
+	desde, hasta := rangoVistaAgenda(vista, fecha)
+	if c.Query("desde") != "" || c.Query("hasta") != "" {
+		var err error
+		if desde, err = time.ParseInLocation("2006-01-02", c.Query("desde"), time.Local); err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Formato de fecha 'desde' inválido. Use YYYY-MM-DD")
+			return
+		}
+		if hasta, err = time.ParseInLocation("2006-01-02", c.Query("hasta"), time.Local); err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Formato de fecha 'hasta' inválido. Use YYYY-MM-DD")
+			return
+		}
+	}
+
+	if hasta.Before(desde) {
+		respuestas.RespondError(c, http.StatusBadRequest, "La fecha 'hasta' debe ser igual o posterior a 'desde'")
+		return
+	}
+
+	if hasta.Sub(desde) > maxDiasDisponibilidad*24*time.Hour {
+		respuestas.RespondError(c, http.StatusBadRequest, "El rango máximo de consulta es de "+strconv.Itoa(maxDiasDisponibilidad)+" días")
+		return
+	}
+
+	queryMedicos := db.Preload("Usuario", sinContrasena).Preload("Usuario.Persona").Preload("Horarios")
+
+	switch c.GetString("userRol") {
+	case "administrador", "recepcionista":
+	case "medico":
+		medico, err := medicoDeUsuario(db, c.GetUint("userID"))
+		if err != nil {
+			respuestas.RespondError(c, http.StatusNotFound, "No se encontró médico asociado a este usuario")
+			return
+		}
+		queryMedicos = queryMedicos.Where("id = ?", medico.ID)
+	default:
+		respuestas.RespondError(c, http.StatusForbidden, "No tienes permiso para consultar la agenda")
+		return
+	}
+
+	if valor := c.Query("medico_id"); valor != "" {
+		var ids []uint
+		for _, parte := range strings.Split(valor, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(parte))
+			if err != nil || id <= 0 {
+				respuestas.RespondError(c, http.StatusBadRequest, "ID de médico inválido: "+parte)
+				return
+			}
+			ids = append(ids, uint(id))
+		}
+		queryMedicos = queryMedicos.Where("id IN ?", ids)
+	}
+
+	if especialidad := c.Query("especialidad"); especialidad != "" {
+		queryMedicos = queryMedicos.Where("especialidad = ?", especialidad)
+	}
+
+	var medicos []models.Medico
+	if err := queryMedicos.Order("id").Find(&medicos).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener médicos: "+err.Error())
+		return
+	}
+
+	medicoIDs := make([]uint, len(medicos))
+	for i, medico := range medicos {
+		medicoIDs[i] = medico.ID
+	}
+
+	finRango := hasta.AddDate(0, 0, 1)
+
+	queryCitas := db.
+		Preload("Paciente", sinContrasena).
+		Preload("Paciente.Persona").
+		Preload("TipoCita").
+		Where("medico_id IN ? AND fecha_cita >= ? AND fecha_cita < ?", medicoIDs, desde, finRango)
+	if c.Query("incluir_canceladas") != "true" {
+		queryCitas = queryCitas.Where("estado <> ?", models.EstadoCancelada)
+	}
+
+	var citas []models.Cita
+	if err := queryCitas.Order("fecha_cita").Find(&citas).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener citas: "+err.Error())
+		return
+	}
+
+	var bloqueos []models.BloqueoAgenda
+	if err := db.
+		Where("medico_id IN ? AND inicio < ? AND fin > ?", medicoIDs, finRango, desde).
+		Order("inicio").
+		Find(&bloqueos).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener bloqueos: "+err.Error())
+		return
+	}
+
+	var festivos []models.DiaFestivo
+	if err := db.Where("fecha BETWEEN ? AND ?", fechaDia(desde), fechaDia(hasta)).Find(&festivos).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener días festivos: "+err.Error())
+		return
+	}
+
+	festivoPorDia := map[string]*models.DiaFestivo{}
+	for i := range festivos {
+		festivoPorDia[festivos[i].Fecha.Format("2006-01-02")] = &festivos[i]
+	}
+
+	// Citas indexadas por médico y día
+	citasPorDia := map[uint]map[string][]models.Cita{}
+	for _, cita := range citas {
+		if citasPorDia[cita.MedicoID] == nil {
+			citasPorDia[cita.MedicoID] = map[string][]models.Cita{}
+		}
+		dia := fechaDia(cita.FechaCita)
+		citasPorDia[cita.MedicoID][dia] = append(citasPorDia[cita.MedicoID][dia], cita)
+	}
+
+	dias := []AgendaDia{}
+	for dia := desde; dia.Before(finRango); dia = dia.AddDate(0, 0, 1) {
+		agendaDia := AgendaDia{
+			Fecha:   fechaDia(dia),
+			Festivo: festivoPorDia[fechaDia(dia)],
+			Medicos: []AgendaMedico{},
+		}
+		finDia := dia.AddDate(0, 0, 1)
+
+		for _, medico := range medicos {
+			agenda := AgendaMedico{
+				MedicoID:     medico.ID,
+				Nombre:       medico.Usuario.Persona.NombreCompleto(),
+				Especialidad: medico.Especialidad,
+				Horarios:     []Slot{},
+				Bloqueos:     []models.BloqueoAgenda{},
+				Citas:        []models.Cita{},
+			}
+
+			// En días festivos la clínica no atiende aunque el médico tenga horario
+			if agendaDia.Festivo == nil {
+				for _, horario := range medico.Horarios {
+					if horario.DiaSemana == models.DiasSemana[dia.Weekday()] {
+						inicio, fin := horario.Rango(dia)
+						agenda.Horarios = append(agenda.Horarios, Slot{Inicio: inicio, Fin: fin})
+					}
+				}
+				sort.Slice(agenda.Horarios, func(i, j int) bool { return agenda.Horarios[i].Inicio.Before(agenda.Horarios[j].Inicio) })
+			}
+
+			for _, bloqueo := range bloqueos {
+				if bloqueo.MedicoID == medico.ID && bloqueo.Traslapa(dia, finDia) {
+					agenda.Bloqueos = append(agenda.Bloqueos, bloqueo)
+				}
+			}
+
+			if citasDia, ok := citasPorDia[medico.ID][agendaDia.Fecha]; ok {
+				agenda.Citas = citasDia
+			}
+
+			// Solo aparecen los médicos con algo que mostrar ese día
+			if len(agenda.Horarios) > 0 || len(agenda.Bloqueos) > 0 || len(agenda.Citas) > 0 {
+				agendaDia.Medicos = append(agendaDia.Medicos, agenda)
+			}
+		}
+
+		dias = append(dias, agendaDia)
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"vista":       vista,
+		"desde":       fechaDia(desde),
+		"hasta":       fechaDia(hasta),
+		"total_citas": len(citas),
+		"dias":        dias,
+	})
+}
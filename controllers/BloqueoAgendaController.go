@@ -0,0 +1,239 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+	"github.com/Ilimm9/CMedicas/paginacion"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type BloqueoAgendaInput struct {
+	Inicio      time.Time  `json:"inicio" binding:"required"`
+	Fin         *time.Time `json:"fin"`          // Opcional en día completo: último día del bloqueo
+	DiaCompleto bool       `json:"dia_completo"` // Cubre los días completos de inicio a fin
+	Motivo      string     `json:"motivo" binding:"required,max=500"`
+	Notificar   bool       `json:"notificar"` // Avisar a los pacientes con citas afectadas
+}
+
+// rangoBloqueo normaliza el intervalo del bloqueo; los de día completo van de la medianoche del
+// primer día a la medianoche siguiente al último
+func rangoBloqueo(input BloqueoAgendaInput) (time.Time, time.Time, bool) {
+	if input.DiaCompleto {
+		inicio := input.Inicio.In(time.Local)
+		ultimo := inicio
+		if input.Fin != nil {
+			ultimo = input.Fin.In(time.Local)
+		}
+		desde := time.Date(inicio.Year(), inicio.Month(), inicio.Day(), 0, 0, 0, 0, time.Local)
+		hasta := time.Date(ultimo.Year(), ultimo.Month(), ultimo.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, 1)
+		return desde, hasta, hasta.After(desde)
+	}
+
+	if input.Fin == nil {
+		return time.Time{}, time.Time{}, false
+	}
+	return input.Inicio, *input.Fin, input.Fin.After(input.Inicio)
+}
+
+// PostBloqueoAgenda registra una ausencia del médico y devuelve las citas activas con las que choca.
+// Si se pide, notifica a los pacientes afectados para que reprogramen.
+func PostBloqueoAgenda(c *gin.Context) {
+	medicoID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID de médico inválido")
+		return
+	}
+
+	var input BloqueoAgendaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	inicio, fin, ok := rangoBloqueo(input)
+	if !ok {
+		respuestas.RespondError(c, http.StatusBadRequest, "Indique un fin posterior al inicio, o use dia_completo")
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	// Bloquear al médico para que no se agenden citas en el intervalo mientras se registra
+	var medico models.Medico
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&medico, medicoID).Error; err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Médico no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar médico: "+err.Error())
+		}
+		return
+	}
+
+	if !verificarAccesoMedico(c, tx, medico.ID) {
+		tx.Rollback()
+		return
+	}
+
+	bloqueo := models.BloqueoAgenda{
+		MedicoID:    medico.ID,
+		Inicio:      inicio,
+		Fin:         fin,
+		DiaCompleto: input.DiaCompleto,
+		Motivo:      input.Motivo,
+		UsuarioID:   c.GetUint("userID"),
+	}
+
+	if err := tx.Create(&bloqueo).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar bloqueo: "+err.Error())
+		return
+	}
+
+	var afectadas []models.Cita
+	if err := tx.Preload("Paciente.Persona").
+		Where("medico_id = ? AND estado IN ?", medico.ID, models.EstadosCitaActivos).
+		Where("fecha_cita < ? AND fecha_fin > ?", fin, inicio).
+		Order("fecha_cita").
+		Find(&afectadas).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar citas afectadas: "+err.Error())
+		return
+	}
+
+	notificadas := 0
+	if input.Notificar {
+		for _, cita := range afectadas {
+			notificacion := models.Notificacion{
+				IDUsuario:  cita.PacienteID,
+				CitaID:     cita.ID,
+				Tipo:       "aviso",
+				Mensaje:    "El médico no estará disponible para su cita del " + fechaLegible(cita.FechaCita) + ". Por favor reprograme su cita",
+				FechaEnvio: time.Now(),
+			}
+
+			if err := tx.Create(&notificacion).Error; err != nil {
+				tx.Rollback()
+				respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear notificación: "+err.Error())
+				return
+			}
+			notificadas++
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, gin.H{
+		"bloqueo":         bloqueo,
+		"citas_afectadas": afectadas,
+		"notificadas":     notificadas,
+	})
+}
+
+// GetBloqueosAgenda lista las ausencias de un médico. Por defecto solo las vigentes o futuras;
+// acepta desde y hasta (YYYY-MM-DD), que incluyen las ausencias que se cruzan con el rango, más los
+// parámetros de los listados
+func GetBloqueosAgenda(c *gin.Context) {
+	medicoID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID de médico inválido")
+		return
+	}
+
+	db := initializers.GetDB()
+	if !verificarAccesoMedico(c, db, uint(medicoID)) {
+		return
+	}
+
+	// El rango se filtra aquí y no con CampoFecha: una ausencia se incluye si se cruza con él
+	query := db.Model(&models.BloqueoAgenda{}).Where("bloqueo_agendas.medico_id = ?", medicoID)
+
+	if valor := c.Query("desde"); valor != "" {
+		desde, err := time.ParseInLocation("2006-01-02", valor, time.Local)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Formato de fecha 'desde' inválido. Use YYYY-MM-DD")
+			return
+		}
+		query = query.Where("bloqueo_agendas.fin > ?", desde)
+	} else {
+		query = query.Where("bloqueo_agendas.fin > ?", time.Now())
+	}
+
+	if valor := c.Query("hasta"); valor != "" {
+		hasta, err := time.ParseInLocation("2006-01-02", valor, time.Local)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Formato de fecha 'hasta' inválido. Use YYYY-MM-DD")
+			return
+		}
+		query = query.Where("bloqueo_agendas.inicio < ?", hasta.AddDate(0, 0, 1))
+	}
+
+	var bloqueos []models.BloqueoAgenda
+	meta, err := paginacion.Paginar(c, query, listadoBloqueosAgenda, &bloqueos)
+	if err != nil {
+		responderErrorListado(c, "Error al obtener bloqueos: ", err)
+		return
+	}
+
+	respuestas.RespondSuccessPaginado(c, http.StatusOK, bloqueos, meta)
+}
+
+// Parámetros admitidos por el listado de ausencias de un médico
+var listadoBloqueosAgenda = paginacion.Listado[models.BloqueoAgenda]{
+	Tabla: "bloqueo_agendas",
+	ID:    func(bloqueo models.BloqueoAgenda) uint { return bloqueo.ID },
+	Orden: map[string]paginacion.Orden[models.BloqueoAgenda]{
+		"id":     {Columna: "bloqueo_agendas.id", Valor: func(bloqueo models.BloqueoAgenda) any { return bloqueo.ID }},
+		"inicio": {Columna: "bloqueo_agendas.inicio", Valor: func(bloqueo models.BloqueoAgenda) any { return bloqueo.Inicio }},
+	},
+	OrdenPorDefecto: "inicio",
+	Busqueda:        []string{"bloqueo_agendas.motivo"},
+}
+
+// DeleteBloqueoAgenda elimina una ausencia y vuelve a liberar ese tiempo en la agenda
+func DeleteBloqueoAgenda(c *gin.Context) {
+	medicoID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID de médico inválido")
+		return
+	}
+
+	bloqueoID, err := strconv.Atoi(c.Param("bloqueo_id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID de bloqueo inválido")
+		return
+	}
+
+	db := initializers.GetDB()
+	if !verificarAccesoMedico(c, db, uint(medicoID)) {
+		return
+	}
+
+	result := db.Where("medico_id = ?", medicoID).Delete(&models.BloqueoAgenda{}, bloqueoID)
+	if result.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al eliminar bloqueo: "+result.Error.Error())
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		respuestas.RespondError(c, http.StatusNotFound, "Bloqueo no encontrado")
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Bloqueo eliminado correctamente"})
+}
@@ -0,0 +1,311 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Formato de fechas UTC de iCalendar
+const formatoICS = "20060102T150405Z"
+
+// nuevoTokenCalendario genera un token aleatorio para la URL de suscripción
+func nuevoTokenCalendario() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// urlPublica devuelve la dirección con la que los clientes externos llegan a la API
+// (variable URL_PUBLICA, o el host de la petición)
+func urlPublica(c *gin.Context) string {
+	if url := os.Getenv("URL_PUBLICA"); url != "" {
+		return strings.TrimRight(url, "/")
+	}
+	esquema := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		esquema = "https"
+	}
+	return esquema + "://" + c.Request.Host
+}
+
+// escaparICS escapa un texto para un valor de iCalendar
+func escaparICS(texto string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(texto)
+}
+
+// lineaICS agrega una propiedad doblando las líneas de más de 75 bytes como pide el RFC 5545
+func lineaICS(b *strings.Builder, propiedad, valor string) {
+	linea := propiedad + ":" + valor
+	for len(linea) > 75 {
+		corte := 75
+		// No partir un carácter UTF-8 a la mitad
+		for corte > 0 && linea[corte]&0xC0 == 0x80 {
+			corte--
+		}
+		b.WriteString(linea[:corte] + "\r\n")
+		linea = " " + linea[corte:]
+	}
+	b.WriteString(linea + "\r\n")
+}
+
+// estadoICS traduce el estado de la cita al STATUS de un VEVENT
+func estadoICS(estado string) string {
+	switch estado {
+	case models.EstadoCancelada, models.EstadoNoAsistio:
+		return "CANCELLED"
+	case models.EstadoReservada, models.EstadoPorAprobar:
+		return "TENTATIVE"
+	}
+	return "CONFIRMED"
+}
+
+// generarICS arma un calendario con las citas. El resumen se escribe desde la perspectiva del rol:
+// el paciente ve con qué médico es la cita y el personal ve al paciente. El enlace de videollamada
+// solo se incluye para el paciente y el médico de la cita, y si la sala ya existe: el feed es una
+// ruta pública y solo lee, nunca crea salas.
+func generarICS(db *gorm.DB, nombre string, citas []models.Cita, rol string) (string, error) {
+	direccion := os.Getenv("CLINICA_DIRECCION")
+	if direccion == "" {
+		direccion = "CMedicas"
+	}
+
+	enlaces := map[uint]string{}
+	if rol == "paciente" || rol == "medico" {
+		ids := []uint{}
+		for _, cita := range citas {
+			if cita.Modalidad == models.ModalidadVideollamada {
+				ids = append(ids, cita.ID)
+			}
+		}
+		if len(ids) > 0 {
+			var salas []models.SalaVirtual
+			if err := db.Where("cita_id IN ?", ids).Find(&salas).Error; err != nil {
+				return "", err
+			}
+			for _, sala := range salas {
+				enlaces[sala.CitaID] = sala.URL
+			}
+		}
+	}
+
+	var b strings.Builder
+	lineaICS(&b, "BEGIN", "VCALENDAR")
+	lineaICS(&b, "VERSION", "2.0")
+	lineaICS(&b, "PRODID", "-//CMedicas//Citas//ES")
+	lineaICS(&b, "CALSCALE", "GREGORIAN")
+	lineaICS(&b, "METHOD", "PUBLISH")
+	lineaICS(&b, "X-WR-CALNAME", escaparICS(nombre))
+
+	ahora := time.Now().UTC().Format(formatoICS)
+	for _, cita := range citas {
+		resumen := "Cita: " + cita.Paciente.Persona.NombreCompleto()
+		if rol == "paciente" {
+			resumen = "Cita con " + cita.Medico.Usuario.Persona.NombreCompleto() + " (" + cita.Medico.Especialidad + ")"
+		}
+
+		descripcion := "Motivo: " + cita.Motivo + "\nEstado: " + cita.Estado
+		if cita.TipoCita != nil {
+			descripcion += "\nTipo: " + cita.TipoCita.Nombre
+		}
+
+		ubicacion := direccion
+		if cita.Modalidad == models.ModalidadVideollamada {
+			ubicacion = "Videollamada"
+			if enlace, ok := enlaces[cita.ID]; ok {
+				ubicacion = enlace
+			}
+		}
+
+		lineaICS(&b, "BEGIN", "VEVENT")
+		lineaICS(&b, "UID", "cita-"+strconv.FormatUint(uint64(cita.ID), 10)+"@cmedicas")
+		lineaICS(&b, "DTSTAMP", ahora)
+		lineaICS(&b, "DTSTART", cita.FechaCita.UTC().Format(formatoICS))
+		lineaICS(&b, "DTEND", cita.FechaFin.UTC().Format(formatoICS))
+		lineaICS(&b, "SUMMARY", escaparICS(resumen))
+		lineaICS(&b, "DESCRIPTION", escaparICS(descripcion))
+		lineaICS(&b, "LOCATION", escaparICS(ubicacion))
+		lineaICS(&b, "STATUS", estadoICS(cita.Estado))
+		lineaICS(&b, "END", "VEVENT")
+	}
+
+	lineaICS(&b, "END", "VCALENDAR")
+	return b.String(), nil
+}
+
+// tokenCalendarioUsuario obtiene el token del usuario y lo crea si aún no tiene
+func tokenCalendarioUsuario(db *gorm.DB, usuarioID uint) (models.CalendarioToken, error) {
+	var token models.CalendarioToken
+	err := db.Where("usuario_id = ?", usuarioID).First(&token).Error
+	if err != gorm.ErrRecordNotFound {
+		return token, err
+	}
+
+	valor, err := nuevoTokenCalendario()
+	if err != nil {
+		return token, err
+	}
+	token = models.CalendarioToken{UsuarioID: usuarioID, Token: valor}
+	return token, db.Create(&token).Error
+}
+
+// rolConCalendario indica si el rol puede suscribir un calendario externo. Solo el paciente y el
+// médico tienen citas propias; un feed del personal expondría todas las citas en una URL pública.
+func rolConCalendario(rol string) bool {
+	return rol == "paciente" || rol == "medico"
+}
+
+// GetSuscripcionCalendario devuelve la URL secreta para suscribir un calendario externo a las
+// citas del usuario actual
+func GetSuscripcionCalendario(c *gin.Context) {
+	if !rolConCalendario(c.GetString("userRol")) {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo los pacientes y médicos pueden suscribir un calendario a sus citas")
+		return
+	}
+
+	token, err := tokenCalendarioUsuario(initializers.GetDB(), c.GetUint("userID"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener suscripción: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"url":       urlPublica(c) + "/api/calendario/feed/" + token.Token + ".ics",
+		"creada_en": token.CreadoEn,
+	})
+}
+
+// RotarSuscripcionCalendario reemplaza el token del usuario; la URL anterior deja de funcionar
+func RotarSuscripcionCalendario(c *gin.Context) {
+	if !rolConCalendario(c.GetString("userRol")) {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo los pacientes y médicos pueden suscribir un calendario a sus citas")
+		return
+	}
+
+	db := initializers.GetDB()
+
+	token, err := tokenCalendarioUsuario(db, c.GetUint("userID"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener suscripción: "+err.Error())
+		return
+	}
+
+	valor, err := nuevoTokenCalendario()
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar token: "+err.Error())
+		return
+	}
+
+	token.Token = valor
+	token.CreadoEn = time.Now()
+	if err := db.Save(&token).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al rotar token: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"url":       urlPublica(c) + "/api/calendario/feed/" + token.Token + ".ics",
+		"creada_en": token.CreadoEn,
+	})
+}
+
+// GetFeedCalendario entrega en formato iCalendar las citas del dueño del token. Es una ruta
+// pública: el token secreto de la URL sustituye al JWT, ya que los calendarios externos no lo envían.
+func GetFeedCalendario(c *gin.Context) {
+	valor := strings.TrimSuffix(c.Param("token"), ".ics")
+
+	db := initializers.GetDB()
+
+	var token models.CalendarioToken
+	if err := db.Preload("Usuario").Where("token = ?", valor).First(&token).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Calendario no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar calendario: "+err.Error())
+		}
+		return
+	}
+
+	// El rol se comprueba al leer el feed: un usuario que cambió de rol pierde el acceso
+	if !rolConCalendario(token.Usuario.Rol) {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo los pacientes y médicos pueden suscribir un calendario a sus citas")
+		return
+	}
+
+	query, err := consultaCitasUsuario(db, token.UsuarioID, token.Usuario.Rol)
+	if err != nil {
+		responderErrorCitasUsuario(c, err)
+		return
+	}
+
+	var citas []models.Cita
+	if err := query.
+		Preload("Paciente.Persona").
+		Preload("Medico.Usuario.Persona").
+		Preload("TipoCita").
+		Order("fecha_cita").
+		Find(&citas).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener citas: "+err.Error())
+		return
+	}
+
+	ics, err := generarICS(db, "Citas CMedicas", citas, token.Usuario.Rol)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar calendario: "+err.Error())
+		return
+	}
+
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(ics))
+}
+
+// GetCitaICS descarga una cita como archivo .ics
+func GetCitaICS(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	db := initializers.GetDB()
+
+	var cita models.Cita
+	if err := db.
+		Preload("Paciente.Persona").
+		Preload("Medico.Usuario.Persona").
+		Preload("TipoCita").
+		First(&cita, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Cita no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar cita: "+err.Error())
+		}
+		return
+	}
+
+	if !verificarAccesoCita(c, db, cita) {
+		return
+	}
+
+	// verificarAccesoCita ya limita al paciente y al médico a sus propias citas
+	ics, err := generarICS(db, "Cita CMedicas", []models.Cita{cita}, c.GetString("userRol"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar calendario: "+err.Error())
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=cita-"+strconv.Itoa(id)+".ics")
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(ics))
+}
@@ -1,34 +1,42 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Ilimm9/CMedicas/Respuestas"
 	"github.com/Ilimm9/CMedicas/initializers"
 	"github.com/Ilimm9/CMedicas/models"
+	"github.com/Ilimm9/CMedicas/paginacion"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type CitaInput struct {
-	PacienteID uint      `json:"paciente_id" binding:"required"`
-	MedicoID   uint      `json:"medico_id" binding:"required"`
-	FechaCita  time.Time `json:"fecha_cita" binding:"required"`
-	Motivo     string    `json:"motivo" binding:"required,max=500"`
+	PacienteID      uint      `json:"paciente_id" binding:"required"`
+	MedicoID        uint      `json:"medico_id" binding:"required"`
+	FechaCita       time.Time `json:"fecha_cita" binding:"required"`
+	TipoCitaID      *uint     `json:"tipo_cita_id"`                                       // Define duración y precio
+	DuracionMinutos int       `json:"duracion_minutos" binding:"omitempty,min=5,max=480"` // Opcional, por defecto la del tipo o del médico
+	Motivo          string    `json:"motivo" binding:"required,max=500"`
+	Modalidad       string    `json:"modalidad" binding:"omitempty,oneof=presencial videollamada"` // Presencial por defecto
 }
 
-// Crear una nueva cita
-func PostCita(c *gin.Context) {
-	var input CitaInput
-
-	if err := c.ShouldBindJSON(&input); err != nil {
-		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
-		return
-	}
+// fechaLegible da formato a una fecha para los mensajes de notificación
+func fechaLegible(t time.Time) string {
+	return t.In(time.Local).Format("02/01/2006 15:04")
+}
 
+// nuevaCita valida la información de una cita (paciente, médico, tipo y fecha) y resuelve su
+// duración y precio. Si algo falla responde el error y devuelve false.
+func nuevaCita(c *gin.Context, input CitaInput) (models.Cita, bool) {
 	// Verificar que el paciente existe
 	var paciente models.Usuario
 	if err := initializers.GetDB().First(&paciente, input.PacienteID).Error; err != nil {
@@ -37,7 +45,7 @@ func PostCita(c *gin.Context) {
 		} else {
 			respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar paciente: "+err.Error())
 		}
-		return
+		return models.Cita{}, false
 	}
 
 	// Verificar que el médico existe
@@ -48,12 +56,88 @@ func PostCita(c *gin.Context) {
 		} else {
 			respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar médico: "+err.Error())
 		}
-		return
+		return models.Cita{}, false
+	}
+
+	// Verificar el tipo de cita y que la especialidad del médico lo ofrezca
+	var tipo *models.TipoCita
+	if input.TipoCitaID != nil {
+		tipo = &models.TipoCita{}
+		if err := initializers.GetDB().Preload("Especialidades").First(tipo, *input.TipoCitaID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				respuestas.RespondError(c, http.StatusBadRequest, "Tipo de cita no encontrado")
+			} else {
+				respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar tipo de cita: "+err.Error())
+			}
+			return models.Cita{}, false
+		}
+		if !tipo.Activo {
+			respuestas.RespondError(c, http.StatusBadRequest, "El tipo de cita no está activo")
+			return models.Cita{}, false
+		}
+		if !tipo.OfrecidoPor(medico.Especialidad) {
+			respuestas.RespondError(c, http.StatusBadRequest, "La especialidad del médico no ofrece este tipo de cita")
+			return models.Cita{}, false
+		}
 	}
 
 	// Validar que la fecha sea futura
 	if input.FechaCita.Before(time.Now()) {
 		respuestas.RespondError(c, http.StatusBadRequest, "La fecha de la cita debe ser futura")
+		return models.Cita{}, false
+	}
+
+	duracion := time.Duration(input.DuracionMinutos) * time.Minute
+	precio := 0.0
+	if tipo != nil {
+		precio = tipo.Precio
+		if duracion == 0 {
+			duracion = time.Duration(tipo.DuracionMinutos) * time.Minute
+		}
+	}
+	if duracion == 0 {
+		var err error
+		duracion, err = duracionCitaMedico(initializers.GetDB(), medico)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener duración de la cita: "+err.Error())
+			return models.Cita{}, false
+		}
+	}
+
+	modalidad := input.Modalidad
+	if modalidad == "" {
+		modalidad = models.ModalidadPresencial
+	}
+
+	return models.Cita{
+		PacienteID:      input.PacienteID,
+		MedicoID:        input.MedicoID,
+		FechaCita:       input.FechaCita,
+		DuracionMinutos: int(duracion.Minutes()),
+		TipoCitaID:      input.TipoCitaID,
+		Precio:          precio,
+		Motivo:          input.Motivo,
+		Modalidad:       modalidad,
+		Estado:          models.EstadoProgramada,
+	}, true
+}
+
+// Crear una nueva cita
+func PostCita(c *gin.Context) {
+	var input CitaInput
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cita, ok := nuevaCita(c, input)
+	if !ok {
+		return
+	}
+
+	// Pacientes con muchas inasistencias recientes quedan bloqueados o pendientes de aprobación
+	if cita.Estado, ok = estadoInicialCita(c, initializers.GetDB(), cita.PacienteID); !ok {
 		return
 	}
 
@@ -63,20 +147,35 @@ func PostCita(c *gin.Context) {
 		return
 	}
 
-	cita := models.Cita{
-		PacienteID: input.PacienteID,
-		MedicoID:   input.MedicoID,
-		FechaCita:  input.FechaCita,
-		Motivo:     input.Motivo,
-		Estado:     "programada",
+	// Validar horario del médico y traslapes con otras citas
+	if conflicto, err := validarAgendaCita(tx, cita.MedicoID, cita.FechaCita, cita.Duracion()); err != nil {
+		tx.Rollback()
+		responderErrorAgenda(c, conflicto, err)
+		return
+	}
+
+	// Máximo de citas activas del paciente (total, por médico y por especialidad)
+	if !verificarLimitesCitas(c, tx, cita, 1) {
+		tx.Rollback()
+		return
 	}
 
 	if err := tx.Create(&cita).Error; err != nil {
 		tx.Rollback()
+		if esViolacionDeAgenda(err) {
+			respuestas.RespondError(c, http.StatusConflict, errCitaTraslapada.Error())
+			return
+		}
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar cita: "+err.Error())
 		return
 	}
 
+	if _, err := asignarSalaVirtual(tx, cita); err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear sala virtual: "+err.Error())
+		return
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
 		return
@@ -89,6 +188,7 @@ func PostCita(c *gin.Context) {
 		Preload("Medico").
 		Preload("Medico.Usuario").
 		Preload("Medico.Usuario.Persona").
+		Preload("TipoCita").
 		First(&cita, cita.ID).Error; err != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos de la cita: "+err.Error())
 		return
@@ -105,14 +205,22 @@ func GetCita(c *gin.Context) {
 		return
 	}
 
+	db := initializers.GetDB()
+
+	// Las notificaciones no se incluyen: pueden llevar el enlace de videollamada y se consultan en
+	// la bandeja de cada destinatario
 	var cita models.Cita
-	result := initializers.GetDB().
+	result := db.
 		Preload("Paciente").
 		Preload("Paciente.Persona").
 		Preload("Medico").
 		Preload("Medico.Usuario").
 		Preload("Medico.Usuario.Persona").
-		Preload("Notificaciones").
+		Preload("TipoCita").
+		Preload("Reprogramaciones", func(db *gorm.DB) *gorm.DB { return db.Order("creado_en") }).
+		Preload("Reprogramaciones.Usuario").
+		Preload("Reprogramaciones.Usuario.Persona").
+		Preload("CambiosEstado", func(db *gorm.DB) *gorm.DB { return db.Order("creado_en") }).
 		First(&cita, id)
 
 	if result.Error != nil {
@@ -124,93 +232,137 @@ func GetCita(c *gin.Context) {
 		return
 	}
 
+	if !verificarAccesoCita(c, db, cita) {
+		return
+	}
+
 	respuestas.RespondSuccess(c, http.StatusOK, cita)
 }
 
 // Obtener todas las citas
 func GetAllCitas(c *gin.Context) {
 	var citas []models.Cita
-	result := initializers.GetDB().
-		Preload("Paciente").
-		Preload("Paciente.Persona").
-		Preload("Medico").
-		Preload("Medico.Usuario").
-		Preload("Medico.Usuario.Persona").
-		Find(&citas)
-
-	if result.Error != nil {
-		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener citas: "+result.Error.Error())
+	meta, err := paginacion.Paginar(c, initializers.GetDB(), listadoCitas, &citas)
+	if err != nil {
+		responderErrorListado(c, "Error al obtener citas: ", err)
 		return
 	}
 
-	respuestas.RespondSuccess(c, http.StatusOK, citas)
+	respuestas.RespondSuccessPaginado(c, http.StatusOK, citas, meta)
 }
 
-// GetCitasUsuarioActual obtiene las citas del usuario autenticado según su rol
-func GetCitasUsuarioActual(c *gin.Context) {
-	// Obtener información del usuario autenticado
-	userID, exists := c.Get("userID")
-	if !exists {
-		respuestas.RespondError(c, http.StatusUnauthorized, "No se pudo identificar al usuario")
-		return
-	}
+// Parámetros admitidos por el listado de citas
+var listadoCitas = paginacion.Listado[models.Cita]{
+	Tabla: "cita",
+	ID:    func(cita models.Cita) uint { return cita.ID },
+	Orden: map[string]paginacion.Orden[models.Cita]{
+		"id":         {Columna: "cita.id", Valor: func(cita models.Cita) any { return cita.ID }},
+		"fecha_cita": {Columna: "cita.fecha_cita", Valor: func(cita models.Cita) any { return cita.FechaCita }},
+		"creada_en":  {Columna: "cita.creada_en", Valor: func(cita models.Cita) any { return cita.CreadaEn }},
+	},
+	OrdenPorDefecto: "-fecha_cita",
+	Filtros: map[string]string{
+		"estado":    "cita.estado",
+		"modalidad": "cita.modalidad",
+	},
+	FiltrosNumericos: map[string]string{
+		"medico_id":    "cita.medico_id",
+		"paciente_id":  "cita.paciente_id",
+		"tipo_cita_id": "cita.tipo_cita_id",
+		"serie_id":     "cita.serie_id",
+	},
+	Rangos: map[string]string{
+		"duracion": "cita.duracion_minutos",
+	},
+	CampoFecha: "cita.fecha_cita",
+	Busqueda:   []string{"cita.motivo"},
+	Preload:    []string{"Paciente", "Paciente.Persona", "Medico", "Medico.Usuario", "Medico.Usuario.Persona", "TipoCita"},
+}
 
-	userRol, exists := c.Get("userRol")
-	if !exists {
-		respuestas.RespondError(c, http.StatusUnauthorized, "No se pudo verificar el rol del usuario")
+// responderErrorListado responde 400 si un parámetro del listado es inválido y 500 en otro caso
+func responderErrorListado(c *gin.Context, mensaje string, err error) {
+	var errParametro *paginacion.ErrorParametro
+	if errors.As(err, &errParametro) {
+		respuestas.RespondError(c, http.StatusBadRequest, errParametro.Mensaje)
 		return
 	}
+	respuestas.RespondError(c, http.StatusInternalServerError, mensaje+err.Error())
+}
 
-	var citas []models.Cita
-	query := initializers.GetDB().
-		Preload("Paciente").
-		Preload("Paciente.Persona").
-		Preload("Medico").
-		Preload("Medico.Usuario").
-		Preload("Medico.Usuario.Persona")
+// Errores al obtener las citas de un usuario
+var (
+	errMedicoNoAsociado = errors.New("No se encontró médico asociado a este usuario")
+	errRolSinCitas      = errors.New("Rol no autorizado para ver citas")
+)
 
-	// Filtrar según el rol del usuario
-	switch userRol {
+// consultaCitasUsuario arma la consulta de las citas visibles para un usuario según su rol: el
+// paciente ve las suyas, el médico las de su agenda y el administrador todas. No trae Preload, para
+// que se pueda paginar.
+func consultaCitasUsuario(db *gorm.DB, userID uint, rol string) (*gorm.DB, error) {
+	query := db.Model(&models.Cita{})
+
+	switch rol {
 	case "paciente":
-		query = query.Where("paciente_id = ?", userID)
+		return query.Where("cita.paciente_id = ?", userID), nil
 	case "medico":
 		// Primero obtener el ID del médico asociado a este usuario
-		var medico models.Medico
-		if err := initializers.GetDB().Where("usuario_id = ?", userID).First(&medico).Error; err != nil {
-			respuestas.RespondError(c, http.StatusNotFound, "No se encontró médico asociado a este usuario")
-			return
+		medico, err := medicoDeUsuario(db, userID)
+		if err == gorm.ErrRecordNotFound {
+			return nil, errMedicoNoAsociado
+		}
+		if err != nil {
+			return nil, err
 		}
-		query = query.Where("medico_id = ?", medico.ID)
+		return query.Where("cita.medico_id = ?", medico.ID), nil
 	case "administrador":
 		// Administradores ven todas las citas sin filtro
+		return query, nil
+	}
+	return nil, errRolSinCitas
+}
+
+// responderErrorCitasUsuario traduce los errores de consultaCitasUsuario a la respuesta HTTP
+func responderErrorCitasUsuario(c *gin.Context, err error) {
+	switch err {
+	case errMedicoNoAsociado:
+		respuestas.RespondError(c, http.StatusNotFound, err.Error())
+	case errRolSinCitas:
+		respuestas.RespondError(c, http.StatusForbidden, err.Error())
 	default:
-		respuestas.RespondError(c, http.StatusForbidden, "Rol no autorizado para ver citas")
-		return
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener citas: "+err.Error())
 	}
+}
 
-	// Aplicar filtros opcionales
-	if estado := c.Query("estado"); estado != "" {
-		query = query.Where("estado = ?", estado)
+// GetCitasUsuarioActual obtiene las citas del usuario autenticado según su rol, con los parámetros
+// del listado de citas
+func GetCitasUsuarioActual(c *gin.Context) {
+	// Obtener información del usuario autenticado
+	userID, exists := c.Get("userID")
+	if !exists {
+		respuestas.RespondError(c, http.StatusUnauthorized, "No se pudo identificar al usuario")
+		return
 	}
 
-	if fecha := c.Query("fecha"); fecha != "" {
-		// Formato esperado: YYYY-MM-DD
-		if _, err := time.Parse("2006-01-02", fecha); err != nil {
-			respuestas.RespondError(c, http.StatusBadRequest, "Formato de fecha inválido. Use YYYY-MM-DD")
-			return
-		}
-		query = query.Where("DATE(fecha_cita) = ?", fecha)
+	userRol, exists := c.Get("userRol")
+	if !exists {
+		respuestas.RespondError(c, http.StatusUnauthorized, "No se pudo verificar el rol del usuario")
+		return
 	}
 
-	// Ordenar por fecha de cita (más recientes primero)
-	query = query.Order("fecha_cita DESC")
+	query, err := consultaCitasUsuario(initializers.GetDB(), userID.(uint), userRol.(string))
+	if err != nil {
+		responderErrorCitasUsuario(c, err)
+		return
+	}
 
-	if err := query.Find(&citas).Error; err != nil {
-		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener citas: "+err.Error())
+	var citas []models.Cita
+	meta, err := paginacion.Paginar(c, query, listadoCitas, &citas)
+	if err != nil {
+		responderErrorListado(c, "Error al obtener citas: ", err)
 		return
 	}
 
-	respuestas.RespondSuccess(c, http.StatusOK, citas)
+	respuestas.RespondSuccessPaginado(c, http.StatusOK, citas, meta)
 }
 
 // Actualizar una cita existente
@@ -222,9 +374,11 @@ func UpdateCita(c *gin.Context) {
 	}
 
 	var input struct {
-		FechaCita *time.Time `json:"fecha_cita"`
-		Motivo    string     `json:"motivo" binding:"max=500"`
-		Estado    string     `json:"estado" binding:"omitempty,oneof=programada cancelada completada"`
+		FechaCita       *time.Time `json:"fecha_cita"`
+		DuracionMinutos int        `json:"duracion_minutos" binding:"omitempty,min=5,max=480"`
+		Motivo          string     `json:"motivo" binding:"max=500"`
+		Modalidad       string     `json:"modalidad" binding:"omitempty,oneof=presencial videollamada"`
+		Estado          string     `json:"estado" binding:"omitempty,oneof=reservada por_aprobar programada confirmada en_sala en_consulta completada cancelada no_asistio"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -239,7 +393,7 @@ func UpdateCita(c *gin.Context) {
 	}
 
 	var cita models.Cita
-	if err := tx.First(&cita, id).Error; err != nil {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&cita, id).Error; err != nil {
 		tx.Rollback()
 		if err == gorm.ErrRecordNotFound {
 			respuestas.RespondError(c, http.StatusNotFound, "Cita no encontrada")
@@ -250,27 +404,77 @@ func UpdateCita(c *gin.Context) {
 	}
 
 	// Actualizar solo info dada
+	reagendar := false
+	fechaAnterior := cita.FechaCita
 	if input.FechaCita != nil {
 		if input.FechaCita.Before(time.Now()) {
 			tx.Rollback()
 			respuestas.RespondError(c, http.StatusBadRequest, "La fecha de la cita debe ser futura")
 			return
 		}
+		reagendar = !input.FechaCita.Equal(cita.FechaCita)
 		cita.FechaCita = *input.FechaCita
 	}
+	if input.DuracionMinutos != 0 {
+		reagendar = reagendar || input.DuracionMinutos != cita.DuracionMinutos
+		cita.DuracionMinutos = input.DuracionMinutos
+	}
 	if input.Motivo != "" {
 		cita.Motivo = input.Motivo
 	}
+	if input.Modalidad != "" {
+		cita.Modalidad = input.Modalidad
+	}
+
+	estadoFinal := cita.Estado
 	if input.Estado != "" {
-		cita.Estado = input.Estado
+		estadoFinal = input.Estado
+	}
+	// Volver a activar una cita cancelada o no asistida también ocupa su espacio en la agenda
+	reactivar := models.EstadoActivo(estadoFinal) && !models.EstadoActivo(cita.Estado)
+
+	// Si la cita ocupa un nuevo espacio en la agenda, aplicar las mismas reglas que al crearla
+	if (reagendar && models.EstadoPendiente(estadoFinal)) || reactivar {
+		if conflicto, err := validarAgendaCita(tx, cita.MedicoID, cita.FechaCita, cita.Duracion(), cita.ID); err != nil {
+			tx.Rollback()
+			responderErrorAgenda(c, conflicto, err)
+			return
+		}
 	}
 
 	if err := tx.Save(&cita).Error; err != nil {
 		tx.Rollback()
+		if esViolacionDeAgenda(err) {
+			respuestas.RespondError(c, http.StatusConflict, errCitaTraslapada.Error())
+			return
+		}
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar cita: "+err.Error())
 		return
 	}
 
+	if _, err := asignarSalaVirtual(tx, cita); err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear sala virtual: "+err.Error())
+		return
+	}
+
+	if !cita.FechaCita.Equal(fechaAnterior) {
+		if err := registrarReprogramacion(tx, cita, fechaAnterior, c.GetUint("userID"), ""); err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al registrar reprogramación: "+err.Error())
+			return
+		}
+	}
+
+	// El cambio de estado pasa por la máquina de estados
+	if input.Estado != "" && input.Estado != cita.Estado {
+		if err := cambiarEstadoCita(tx, &cita, input.Estado, "administrador", c.GetUint("userID"), ""); err != nil {
+			tx.Rollback()
+			responderErrorTransicion(c, err)
+			return
+		}
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
 		return
@@ -283,6 +487,7 @@ func UpdateCita(c *gin.Context) {
 		Preload("Medico").
 		Preload("Medico.Usuario").
 		Preload("Medico.Usuario.Persona").
+		Preload("TipoCita").
 		First(&cita, cita.ID).Error; err != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos actualizados: "+err.Error())
 		return
@@ -387,7 +592,38 @@ func GetCitasPorMedico(c *gin.Context) {
 	respuestas.RespondSuccess(c, http.StatusOK, citas)
 }
 
-// Cancelar una cita existente
+// validarCancelacion verifica que una cita pueda cancelarse según su estado y la política de
+// cancelación. Devuelve si la cancelación es tardía y queda penalizada al paciente.
+func validarCancelacion(cita models.Cita, politica models.PoliticaCancelacion, rol string) (bool, error) {
+	// Validar que la cita no esté ya cancelada o completada
+	switch cita.Estado {
+	case models.EstadoCancelada:
+		return false, errors.New("La cita ya está cancelada")
+	case models.EstadoCompletada:
+		return false, errors.New("No se puede cancelar una cita ya completada")
+	case models.EstadoReservada:
+		return false, errors.New("La cita es una oferta de lista de espera, recházela desde la lista de espera")
+	}
+
+	if !models.TransicionValida(cita.Estado, models.EstadoCancelada) {
+		return false, errors.New("No se puede cancelar una cita en estado " + cita.Estado)
+	}
+
+	// El aviso mínimo solo aplica al paciente; las cancelaciones de la clínica no lo penalizan
+	if rol != "paciente" || time.Until(cita.FechaCita) >= politica.Anticipacion() {
+		return false, nil
+	}
+
+	if !politica.PermiteTardia {
+		return false, fmt.Errorf("No se puede cancelar con menos de %d horas de anticipación", politica.AnticipacionHoras)
+	}
+
+	return true, nil
+}
+
+// Cancelar una cita existente según la política de cancelación del médico. Si pertenece a una
+// serie, ?alcance=siguientes cancela también las citas posteriores de la serie y ?alcance=serie
+// todas las que sigan pendientes. El médico debe enviar {"motivo"}, que se guarda y se notifica.
 func CancelarCita(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -395,12 +631,34 @@ func CancelarCita(c *gin.Context) {
 		return
 	}
 
+	alcance := c.DefaultQuery("alcance", alcanceEsta)
+	if !alcanceValido(alcance) {
+		respuestas.RespondError(c, http.StatusBadRequest, "Alcance inválido. Use esta, siguientes o serie")
+		return
+	}
+
 	// informacion del usuario
 	userID, exists := c.Get("userID")
 	if !exists {
 		respuestas.RespondError(c, http.StatusUnauthorized, "No se pudo identificar al usuario")
 		return
 	}
+	rol := c.GetString("userRol")
+
+	// El cuerpo es opcional salvo para médicos, que deben indicar el motivo
+	var input struct {
+		Motivo string `json:"motivo" binding:"max=500"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil && err != io.EOF {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	input.Motivo = strings.TrimSpace(input.Motivo)
+
+	if rol == "medico" && input.Motivo == "" {
+		respuestas.RespondError(c, http.StatusBadRequest, "El médico debe indicar el motivo de la cancelación")
+		return
+	}
 
 	tx := initializers.GetDB().Begin()
 	if tx.Error != nil {
@@ -408,8 +666,9 @@ func CancelarCita(c *gin.Context) {
 		return
 	}
 
+	// Bloquear la cita: dos cancelaciones simultáneas no deben pasar ambas la validación
 	var cita models.Cita
-	if err := tx.Preload("Paciente").First(&cita, id).Error; err != nil {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Paciente").Preload("Medico").First(&cita, id).Error; err != nil {
 		tx.Rollback()
 		if err == gorm.ErrRecordNotFound {
 			respuestas.RespondError(c, http.StatusNotFound, "Cita no encontrada")
@@ -419,56 +678,329 @@ func CancelarCita(c *gin.Context) {
 		return
 	}
 
-	// Verificar permisos, el usuario o admi son los unicos que pueden cancelar
-	if cita.PacienteID != userID.(uint) {
-		userRol, _ := c.Get("userRol")
-		if userRol != "administrador" {
-			tx.Rollback()
-			respuestas.RespondError(c, http.StatusForbidden, "No tienes permiso para cancelar esta cita")
-			return
-		}
+	// Verificar permisos: el paciente, el médico de la cita o un administrador
+	permitido := false
+	switch rol {
+	case "administrador":
+		permitido = true
+	case "paciente":
+		permitido = cita.PacienteID == userID.(uint)
+	case "medico":
+		medico, err := medicoDeUsuario(tx, userID.(uint))
+		permitido = err == nil && medico.ID == cita.MedicoID
+	}
+	if !permitido {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusForbidden, "No tienes permiso para cancelar esta cita")
+		return
 	}
 
-	// Validar que la cita no esté ya cancelada o completada
-	if cita.Estado == "cancelada" {
+	politica, err := politicaCancelacion(tx, cita.Medico)
+	if err != nil {
 		tx.Rollback()
-		respuestas.RespondError(c, http.StatusBadRequest, "La cita ya está cancelada")
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener política de cancelación: "+err.Error())
 		return
 	}
 
-	if cita.Estado == "completada" {
+	if !politica.PermiteRol(rol) {
 		tx.Rollback()
-		respuestas.RespondError(c, http.StatusBadRequest, "No se puede cancelar una cita ya completada")
+		respuestas.RespondError(c, http.StatusForbidden, "La política de cancelación no permite que tu rol cancele esta cita")
 		return
 	}
 
-	// Validar que no se cancele con muy poca anticipación (< de 24 horas)
-	if time.Until(cita.FechaCita) < 24*time.Hour {
+	if _, err := validarCancelacion(cita, politica, rol); err != nil {
 		tx.Rollback()
-		respuestas.RespondError(c, http.StatusBadRequest, "No se puede cancelar con menos de 24 horas de anticipación")
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Actualizar estado de la cita
-	cita.Estado = "cancelada"
-	if err := tx.Save(&cita).Error; err != nil {
+	citas, err := citasDeAlcance(tx, cita, alcance)
+	if err != nil {
 		tx.Rollback()
-		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cancelar cita: "+err.Error())
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener citas de la serie: "+err.Error())
+		return
+	}
+
+	canceladas := []uint{}
+	penalizadas := []uint{}
+	for i := range citas {
+		penalizada, err := validarCancelacion(citas[i], politica, rol)
+		if err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusBadRequest, err.Error()+" (cita del "+fechaLegible(citas[i].FechaCita)+")")
+			return
+		}
+
+		// Actualizar estado de la cita
+		if err := cambiarEstadoCita(tx, &citas[i], models.EstadoCancelada, rol, userID.(uint), input.Motivo); err != nil {
+			tx.Rollback()
+			responderErrorTransicion(c, err)
+			return
+		}
+
+		if err := tx.Model(&citas[i]).Updates(map[string]interface{}{
+			"motivo_cancelacion":     input.Motivo,
+			"cancelacion_penalizada": penalizada,
+		}).Error; err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al cancelar cita: "+err.Error())
+			return
+		}
+		if penalizada {
+			penalizadas = append(penalizadas, citas[i].ID)
+		}
+
+		// Crear notificación de cancelación
+		mensaje := "Su cita del " + fechaLegible(citas[i].FechaCita) + " ha sido cancelada"
+		if rol == "medico" {
+			mensaje = "Su cita del " + fechaLegible(citas[i].FechaCita) + " fue cancelada por el médico"
+		}
+		if input.Motivo != "" {
+			mensaje += ". Motivo: " + input.Motivo
+		}
+		if penalizada {
+			mensaje += ". La cancelación se realizó fuera del plazo y quedó registrada como tardía"
+		}
+
+		notificacion := models.Notificacion{
+			IDUsuario:  citas[i].PacienteID,
+			CitaID:     citas[i].ID,
+			Tipo:       "cancelación",
+			Mensaje:    mensaje,
+			FechaEnvio: time.Now(),
+		}
+
+		if err := tx.Create(&notificacion).Error; err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear notificación: "+err.Error())
+			return
+		}
+
+		// Ofrecer el espacio liberado al primer paciente de la lista de espera
+		if err := ofrecerEspacioListaEspera(tx, citas[i]); err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al ofrecer espacio a lista de espera: "+err.Error())
+			return
+		}
+
+		canceladas = append(canceladas, citas[i].ID)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	if err := initializers.GetDB().
+		Preload("Paciente").
+		Preload("Paciente.Persona").
+		Preload("Medico").
+		Preload("Medico.Usuario").
+		Preload("Medico.Usuario.Persona").
+		Preload("TipoCita").
+		First(&cita, cita.ID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos actualizados: "+err.Error())
 		return
 	}
 
-	// Crear notificación de cancelación
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"message":           "Cita cancelada exitosamente",
+		"cita":              cita,
+		"citas_canceladas":  canceladas,
+		"citas_penalizadas": penalizadas,
+	})
+}
+
+// registrarReprogramacion guarda el cambio de fecha en el historial de la cita y notifica al
+// paciente; si fue el propio paciente quien reprogramó, se notifica al médico
+func registrarReprogramacion(tx *gorm.DB, cita models.Cita, anterior time.Time, usuarioID uint, motivo string) error {
+	historial := models.ReprogramacionCita{
+		CitaID:        cita.ID,
+		FechaAnterior: anterior,
+		FechaNueva:    cita.FechaCita,
+		UsuarioID:     usuarioID,
+		Motivo:        motivo,
+	}
+	if err := tx.Create(&historial).Error; err != nil {
+		return err
+	}
+
+	if err := models.RegistrarEvento(tx, models.EventoCitaReprogramada, cita.ID, map[string]interface{}{
+		"cita_id":        cita.ID,
+		"paciente_id":    cita.PacienteID,
+		"medico_id":      cita.MedicoID,
+		"fecha_anterior": anterior,
+		"fecha_nueva":    cita.FechaCita,
+		"motivo":         motivo,
+	}); err != nil {
+		return err
+	}
+
+	mensaje := "La cita del " + fechaLegible(anterior) + " fue reprogramada para el " + fechaLegible(cita.FechaCita)
+	if motivo != "" {
+		mensaje += ". Motivo: " + motivo
+	}
+
+	destinatario := cita.PacienteID
+	if usuarioID == cita.PacienteID {
+		var medico models.Medico
+		if err := tx.First(&medico, cita.MedicoID).Error; err != nil {
+			return err
+		}
+		destinatario = medico.UsuarioID
+	}
+
+	conSala, err := conSalaVirtual(tx, cita)
+	if err != nil {
+		return err
+	}
+
 	notificacion := models.Notificacion{
-		IDUsuario:  cita.PacienteID,
+		IDUsuario:  destinatario,
 		CitaID:     cita.ID,
-		Tipo:       "cancelación",
-		Mensaje:    "Su cita ha sido cancelada",
+		Tipo:       "reprogramación",
+		Mensaje:    mensaje,
 		FechaEnvio: time.Now(),
+		ConSala:    conSala,
+	}
+	return tx.Create(&notificacion).Error
+}
+
+// verificarReprogramacion comprueba que el usuario pueda cambiar la fecha de la cita: el paciente y
+// el médico de la cita si la política de cancelación del médico se lo permite, el paciente además
+// con su anticipación mínima, y siempre un administrador. Si no puede responde el error y devuelve false.
+func verificarReprogramacion(c *gin.Context, tx *gorm.DB, cita models.Cita) bool {
+	rol := c.GetString("userRol")
+	userID := c.GetUint("userID")
+
+	permitido := false
+	switch rol {
+	case "administrador":
+		return true
+	case "paciente":
+		permitido = cita.PacienteID == userID
+	case "medico":
+		medico, err := medicoDeUsuario(tx, userID)
+		permitido = err == nil && medico.ID == cita.MedicoID
+	}
+	if !permitido {
+		respuestas.RespondError(c, http.StatusForbidden, "No tienes permiso para reprogramar esta cita")
+		return false
+	}
+
+	var medico models.Medico
+	if err := tx.First(&medico, cita.MedicoID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar médico: "+err.Error())
+		return false
+	}
+
+	politica, err := politicaCancelacion(tx, medico)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener política de cancelación: "+err.Error())
+		return false
+	}
+
+	if !politica.PermiteRol(rol) {
+		respuestas.RespondError(c, http.StatusForbidden, "La política de cancelación no permite que tu rol reprograme esta cita")
+		return false
+	}
+
+	if rol == "paciente" && time.Until(cita.FechaCita) < politica.Anticipacion() {
+		respuestas.RespondError(c, http.StatusBadRequest, fmt.Sprintf("No se puede reprogramar con menos de %d horas de anticipación", politica.AnticipacionHoras))
+		return false
+	}
+
+	return true
+}
+
+// ReprogramarCita cambia la fecha de una cita validando el nuevo horario. Pueden hacerlo el paciente
+// y el médico de la cita, según la política de cancelación del médico, o un administrador.
+func ReprogramarCita(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var input struct {
+		FechaCita time.Time `json:"fecha_cita" binding:"required"`
+		Motivo    string    `json:"motivo" binding:"max=500"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID := c.GetUint("userID")
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	// Bloquear la cita para que una cancelación o cambio de estado concurrente no se cruce
+	var cita models.Cita
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&cita, id).Error; err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Cita no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar cita: "+err.Error())
+		}
+		return
+	}
+
+	if !verificarReprogramacion(c, tx, cita) {
+		tx.Rollback()
+		return
+	}
+
+	if !models.EstadoPendiente(cita.Estado) {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusBadRequest, "Solo se pueden reprogramar citas que no han comenzado")
+		return
+	}
+
+	if input.FechaCita.Before(time.Now()) {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusBadRequest, "La fecha de la cita debe ser futura")
+		return
+	}
+
+	if input.FechaCita.Equal(cita.FechaCita) {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusBadRequest, "La nueva fecha es igual a la actual")
+		return
+	}
+
+	if conflicto, err := validarAgendaCita(tx, cita.MedicoID, input.FechaCita, cita.Duracion(), cita.ID); err != nil {
+		tx.Rollback()
+		responderErrorAgenda(c, conflicto, err)
+		return
+	}
+
+	// Solo se actualizan las fechas para no pisar otros campos de la cita
+	anterior := cita.FechaCita
+	cita.FechaCita = input.FechaCita
+	cita.FechaFin = cita.FechaCita.Add(cita.Duracion())
+	if err := tx.Model(&cita).Updates(map[string]interface{}{
+		"fecha_cita": cita.FechaCita,
+		"fecha_fin":  cita.FechaFin,
+	}).Error; err != nil {
+		tx.Rollback()
+		if esViolacionDeAgenda(err) {
+			respuestas.RespondError(c, http.StatusConflict, errCitaTraslapada.Error())
+			return
+		}
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al reprogramar cita: "+err.Error())
+		return
 	}
 
-	if err := tx.Create(&notificacion).Error; err != nil {
+	if err := registrarReprogramacion(tx, cita, anterior, userID, input.Motivo); err != nil {
 		tx.Rollback()
-		respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear notificación: "+err.Error())
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al registrar reprogramación: "+err.Error())
 		return
 	}
 
@@ -483,13 +1015,209 @@ func CancelarCita(c *gin.Context) {
 		Preload("Medico").
 		Preload("Medico.Usuario").
 		Preload("Medico.Usuario.Persona").
+		Preload("TipoCita").
+		Preload("Reprogramaciones", func(db *gorm.DB) *gorm.DB { return db.Order("creado_en") }).
 		First(&cita, cita.ID).Error; err != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos actualizados: "+err.Error())
 		return
 	}
 
 	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
-		"message": "Cita cancelada exitosamente",
+		"message": "Cita reprogramada exitosamente",
 		"cita":    cita,
 	})
 }
+
+// cambiarEstadoCita aplica una transición de la máquina de estados y la registra con su fecha en el
+// historial. Para cambios automáticos se usa rol models.RolSistema y usuarioID 0.
+func cambiarEstadoCita(tx *gorm.DB, cita *models.Cita, nuevo, rol string, usuarioID uint, motivo string) error {
+	// Validar contra el estado actual con la fila bloqueada: otra transacción pudo cambiarlo
+	// desde que se leyó la cita
+	var actual models.Cita
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "estado").First(&actual, cita.ID).Error; err != nil {
+		return err
+	}
+	cita.Estado = actual.Estado
+
+	if err := models.ValidarTransicion(cita.Estado, nuevo, rol); err != nil {
+		return err
+	}
+
+	cambio := models.CambioEstadoCita{
+		CitaID:         cita.ID,
+		EstadoAnterior: cita.Estado,
+		EstadoNuevo:    nuevo,
+		Rol:            rol,
+		Motivo:         motivo,
+	}
+	if usuarioID != 0 {
+		cambio.UsuarioID = &usuarioID
+	}
+
+	cambios := map[string]interface{}{"estado": nuevo}
+
+	// La llegada a sala marca la hora de registro en recepción
+	if nuevo == models.EstadoEnSala {
+		ahora := time.Now()
+		cambios["llegada_en"] = ahora
+		cita.LlegadaEn = &ahora
+	}
+
+	if err := tx.Model(cita).Updates(cambios).Error; err != nil {
+		return err
+	}
+	cita.Estado = nuevo
+
+	// Contador de inasistencias del paciente
+	if nuevo == models.EstadoNoAsistio {
+		if err := tx.Model(&models.Usuario{}).Where("id = ?", cita.PacienteID).
+			UpdateColumn("inasistencias", gorm.Expr("inasistencias + 1")).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Create(&cambio).Error; err != nil {
+		return err
+	}
+
+	return models.RegistrarEvento(tx, models.EventoCitaEstado, cita.ID, map[string]interface{}{
+		"cita_id":         cita.ID,
+		"paciente_id":     cita.PacienteID,
+		"medico_id":       cita.MedicoID,
+		"fecha_cita":      cita.FechaCita,
+		"estado_anterior": cambio.EstadoAnterior,
+		"estado_nuevo":    nuevo,
+		"rol":             rol,
+		"motivo":          motivo,
+	})
+}
+
+// responderErrorTransicion traduce los errores de cambiarEstadoCita a la respuesta HTTP
+func responderErrorTransicion(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, models.ErrTransicionNoPermitida):
+		respuestas.RespondError(c, http.StatusForbidden, err.Error())
+	case errors.Is(err, models.ErrTransicionInvalida):
+		respuestas.RespondError(c, http.StatusConflict, err.Error())
+	case esViolacionDeAgenda(err):
+		respuestas.RespondError(c, http.StatusConflict, errCitaTraslapada.Error())
+	default:
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cambiar estado de la cita: "+err.Error())
+	}
+}
+
+// verificarAccesoCita comprueba que el usuario sea el paciente o el médico de la cita, o personal
+// de la clínica. Si no tiene acceso responde el error y devuelve false.
+func verificarAccesoCita(c *gin.Context, db *gorm.DB, cita models.Cita) bool {
+	userID := c.GetUint("userID")
+
+	switch c.GetString("userRol") {
+	case "administrador", "recepcionista":
+		return true
+	case "medico":
+		medico, err := medicoDeUsuario(db, userID)
+		if err == nil && medico.ID == cita.MedicoID {
+			return true
+		}
+	case "paciente":
+		if cita.PacienteID == userID {
+			return true
+		}
+	}
+
+	respuestas.RespondError(c, http.StatusForbidden, "No tienes permiso sobre esta cita")
+	return false
+}
+
+// CambiarEstadoCita avanza una cita en su flujo (confirmar, llegada a sala, inicio de consulta,
+// completar o no asistió) según la máquina de estados y el rol del usuario.
+// Las cancelaciones se hacen con CancelarCita para aplicar sus reglas y notificaciones.
+func CambiarEstadoCita(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var input struct {
+		Estado string `json:"estado" binding:"required,oneof=programada confirmada en_sala en_consulta completada no_asistio"`
+		Motivo string `json:"motivo" binding:"max=500"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	var cita models.Cita
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&cita, id).Error; err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Cita no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar cita: "+err.Error())
+		}
+		return
+	}
+
+	if !verificarAccesoCita(c, tx, cita) {
+		tx.Rollback()
+		return
+	}
+
+	if err := cambiarEstadoCita(tx, &cita, input.Estado, c.GetString("userRol"), c.GetUint("userID"), input.Motivo); err != nil {
+		tx.Rollback()
+		responderErrorTransicion(c, err)
+		return
+	}
+
+	if cita.Estado == models.EstadoConfirmada {
+		conSala, err := conSalaVirtual(tx, cita)
+		if err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener sala virtual: "+err.Error())
+			return
+		}
+
+		notificacion := models.Notificacion{
+			IDUsuario:  cita.PacienteID,
+			CitaID:     cita.ID,
+			Tipo:       "confirmación",
+			Mensaje:    "Su cita del " + fechaLegible(cita.FechaCita) + " ha sido confirmada",
+			FechaEnvio: time.Now(),
+			ConSala:    conSala,
+		}
+
+		if err := tx.Create(&notificacion).Error; err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear notificación: "+err.Error())
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	if err := initializers.GetDB().
+		Preload("Paciente").
+		Preload("Paciente.Persona").
+		Preload("Medico").
+		Preload("Medico.Usuario").
+		Preload("Medico.Usuario.Persona").
+		Preload("TipoCita").
+		Preload("CambiosEstado", func(db *gorm.DB) *gorm.DB { return db.Order("creado_en") }).
+		First(&cita, cita.ID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos actualizados: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, cita)
+}
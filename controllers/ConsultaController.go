@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SeguimientoInput struct {
+	FechaCita       time.Time `json:"fecha_cita" binding:"required"`
+	TipoCitaID      *uint     `json:"tipo_cita_id"`
+	DuracionMinutos int       `json:"duracion_minutos" binding:"omitempty,min=5,max=480"`
+	Motivo          string    `json:"motivo" binding:"max=500"`
+	Modalidad       string    `json:"modalidad" binding:"omitempty,oneof=presencial videollamada"` // Por defecto la de la cita actual
+}
+
+type FinalizarConsultaInput struct {
+	Observaciones string            `json:"observaciones" binding:"required"`
+	Diagnostico   string            `json:"diagnostico"`
+	Seguimiento   *SeguimientoInput `json:"seguimiento"` // Cita de seguimiento opcional
+}
+
+// FinalizarConsulta permite al médico cerrar una de sus citas en un solo paso: registra la
+// observación y el diagnóstico, marca la cita como completada, agenda el seguimiento si se pide
+// y notifica al paciente. Todo ocurre en una transacción.
+func FinalizarConsulta(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	if c.GetString("userRol") != "medico" {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo el médico de la cita puede finalizar la consulta")
+		return
+	}
+
+	var input FinalizarConsultaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID := c.GetUint("userID")
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	var cita models.Cita
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&cita, id).Error; err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Cita no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar cita: "+err.Error())
+		}
+		return
+	}
+
+	// El médico del token debe ser el de la cita
+	medico, err := medicoDeUsuario(tx, userID)
+	if err != nil || medico.ID != cita.MedicoID {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusForbidden, "Solo el médico de la cita puede finalizar la consulta")
+		return
+	}
+
+	// Si la consulta no ha iniciado (el paciente está en sala, o no pasó por recepción como en las
+	// videollamadas), inicia y termina en este paso
+	if cita.Estado == models.EstadoEnSala || models.EstadoPendiente(cita.Estado) {
+		if err := cambiarEstadoCita(tx, &cita, models.EstadoEnConsulta, "medico", userID, ""); err != nil {
+			tx.Rollback()
+			responderErrorTransicion(c, err)
+			return
+		}
+	}
+
+	if cita.Estado != models.EstadoEnConsulta {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusConflict, "Solo se pueden finalizar citas programadas, confirmadas, en sala o en consulta")
+		return
+	}
+
+	var existentes int64
+	if err := tx.Model(&models.Observacion{}).Where("cita_id = ?", cita.ID).Count(&existentes).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar observación: "+err.Error())
+		return
+	}
+
+	if existentes > 0 {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusConflict, "La cita ya tiene una observación registrada")
+		return
+	}
+
+	observacion := models.Observacion{
+		CitaID:        cita.ID,
+		Observaciones: input.Observaciones,
+		Diagnostico:   input.Diagnostico,
+		FechaRegistro: time.Now(),
+	}
+
+	if err := tx.Create(&observacion).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar observación: "+err.Error())
+		return
+	}
+
+	if err := cambiarEstadoCita(tx, &cita, models.EstadoCompletada, "medico", userID, ""); err != nil {
+		tx.Rollback()
+		responderErrorTransicion(c, err)
+		return
+	}
+
+	mensaje := "Su consulta del " + fechaLegible(cita.FechaCita) + " ha finalizado. Puede consultar las indicaciones de su médico"
+
+	// Cita de seguimiento: la agenda el médico, por lo que no aplican las restricciones de reserva del paciente
+	var seguimiento *models.Cita
+	if input.Seguimiento != nil {
+		motivo := input.Seguimiento.Motivo
+		if motivo == "" {
+			motivo = "Seguimiento de la cita del " + fechaLegible(cita.FechaCita)
+		}
+
+		modalidad := input.Seguimiento.Modalidad
+		if modalidad == "" {
+			modalidad = cita.Modalidad
+		}
+
+		nueva, ok := nuevaCita(c, CitaInput{
+			PacienteID:      cita.PacienteID,
+			MedicoID:        cita.MedicoID,
+			FechaCita:       input.Seguimiento.FechaCita,
+			TipoCitaID:      input.Seguimiento.TipoCitaID,
+			DuracionMinutos: input.Seguimiento.DuracionMinutos,
+			Motivo:          motivo,
+			Modalidad:       modalidad,
+		})
+		if !ok {
+			tx.Rollback()
+			return
+		}
+
+		if conflicto, err := validarAgendaCita(tx, nueva.MedicoID, nueva.FechaCita, nueva.Duracion()); err != nil {
+			tx.Rollback()
+			responderErrorAgenda(c, conflicto, err)
+			return
+		}
+
+		if err := tx.Create(&nueva).Error; err != nil {
+			tx.Rollback()
+			if esViolacionDeAgenda(err) {
+				respuestas.RespondError(c, http.StatusConflict, errCitaTraslapada.Error())
+				return
+			}
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar cita de seguimiento: "+err.Error())
+			return
+		}
+
+		if _, err := asignarSalaVirtual(tx, nueva); err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear sala virtual: "+err.Error())
+			return
+		}
+
+		seguimiento = &nueva
+		mensaje += ". Se agendó su cita de seguimiento para el " + fechaLegible(nueva.FechaCita)
+	}
+
+	notificacion := models.Notificacion{
+		IDUsuario:  cita.PacienteID,
+		CitaID:     cita.ID,
+		Tipo:       "aviso",
+		Mensaje:    mensaje,
+		FechaEnvio: time.Now(),
+	}
+
+	if err := tx.Create(&notificacion).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear notificación: "+err.Error())
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	if err := initializers.GetDB().
+		Preload("Paciente").
+		Preload("Paciente.Persona").
+		Preload("TipoCita").
+		Preload("CambiosEstado", func(db *gorm.DB) *gorm.DB { return db.Order("creado_en") }).
+		First(&cita, cita.ID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos actualizados: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"cita":        cita,
+		"observacion": observacion,
+		"seguimiento": seguimiento,
+	})
+}
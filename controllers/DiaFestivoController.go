@@ -0,0 +1,307 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type DiaFestivoInput struct {
+	Fecha  string `json:"fecha" binding:"required"` // YYYY-MM-DD
+	Nombre string `json:"nombre" binding:"required,max=100"`
+}
+
+var errDiaFestivo = errors.New("La clínica está cerrada ese día")
+
+// fechaDia devuelve la fecha local del día como texto YYYY-MM-DD
+func fechaDia(t time.Time) string {
+	return t.In(time.Local).Format("2006-01-02")
+}
+
+// diaFestivo devuelve el día festivo que cae en la fecha indicada, o nil
+func diaFestivo(db *gorm.DB, fecha time.Time) (*models.DiaFestivo, error) {
+	var festivo models.DiaFestivo
+	err := db.Where("fecha = ?", fechaDia(fecha)).First(&festivo).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &festivo, nil
+}
+
+// festivosEnRango devuelve los días festivos entre desde y hasta, indexados por YYYY-MM-DD
+func festivosEnRango(db *gorm.DB, desde, hasta time.Time) (map[string]bool, error) {
+	var festivos []models.DiaFestivo
+	if err := db.Where("fecha BETWEEN ? AND ?", fechaDia(desde), fechaDia(hasta)).Find(&festivos).Error; err != nil {
+		return nil, err
+	}
+
+	dias := map[string]bool{}
+	for _, festivo := range festivos {
+		dias[festivo.Fecha.Format("2006-01-02")] = true
+	}
+	return dias, nil
+}
+
+// leerDiasFestivos interpreta una lista de días festivos en JSON ([{"fecha", "nombre"}]) o CSV
+// (fecha,nombre con encabezado opcional). Devuelve los errores por registro sin detenerse.
+func leerDiasFestivos(formato string, r io.Reader) ([]models.DiaFestivo, []string, error) {
+	var registros []DiaFestivoInput
+
+	switch formato {
+	case "json":
+		if err := json.NewDecoder(r).Decode(&registros); err != nil {
+			return nil, nil, err
+		}
+	case "csv":
+		lector := csv.NewReader(r)
+		lector.FieldsPerRecord = -1
+		lector.TrimLeadingSpace = true
+		filas, err := lector.ReadAll()
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, fila := range filas {
+			if i == 0 && len(fila) > 0 && strings.EqualFold(strings.TrimSpace(fila[0]), "fecha") {
+				continue
+			}
+			registro := DiaFestivoInput{}
+			if len(fila) > 0 {
+				registro.Fecha = strings.TrimSpace(fila[0])
+			}
+			if len(fila) > 1 {
+				registro.Nombre = strings.TrimSpace(fila[1])
+			}
+			registros = append(registros, registro)
+		}
+	default:
+		return nil, nil, errors.New("Formato no soportado, use json o csv")
+	}
+
+	festivos := []models.DiaFestivo{}
+	errores := []string{}
+	// Una fecha repetida haría fallar el upsert, que no puede actualizar la misma fila dos veces
+	vistas := map[string]int{}
+	for i, registro := range registros {
+		fecha, err := time.ParseInLocation("2006-01-02", registro.Fecha, time.Local)
+		if err != nil {
+			errores = append(errores, fmt.Sprintf("Registro %d: fecha '%s' inválida, use YYYY-MM-DD", i+1, registro.Fecha))
+			continue
+		}
+		dia := fecha.Format("2006-01-02")
+		if primero, ok := vistas[dia]; ok {
+			errores = append(errores, fmt.Sprintf("Registro %d: la fecha %s ya aparece en el registro %d", i+1, dia, primero))
+			continue
+		}
+		vistas[dia] = i + 1
+		if registro.Nombre == "" || len(registro.Nombre) > 100 {
+			errores = append(errores, fmt.Sprintf("Registro %d: el nombre es obligatorio y de máximo 100 caracteres", i+1))
+			continue
+		}
+		festivos = append(festivos, models.DiaFestivo{Fecha: fecha, Nombre: registro.Nombre})
+	}
+
+	return festivos, errores, nil
+}
+
+// PostDiaFestivo agrega un día al calendario de días festivos
+func PostDiaFestivo(c *gin.Context) {
+	var input DiaFestivoInput
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	fecha, err := time.ParseInLocation("2006-01-02", input.Fecha, time.Local)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "Formato de fecha inválido. Use YYYY-MM-DD")
+		return
+	}
+
+	existente, err := diaFestivo(initializers.GetDB(), fecha)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar fecha: "+err.Error())
+		return
+	}
+	if existente != nil {
+		respuestas.RespondError(c, http.StatusConflict, "Ya existe un día festivo en esa fecha")
+		return
+	}
+
+	festivo := models.DiaFestivo{Fecha: fecha, Nombre: input.Nombre}
+	if err := initializers.GetDB().Create(&festivo).Error; err != nil {
+		// Otra petición pudo registrar la misma fecha después de la verificación
+		if esViolacionUnica(err) {
+			respuestas.RespondError(c, http.StatusConflict, "Ya existe un día festivo en esa fecha")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar día festivo: "+err.Error())
+		}
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, festivo)
+}
+
+// GetDiasFestivos obtiene el calendario de días festivos, opcionalmente de un año
+func GetDiasFestivos(c *gin.Context) {
+	query := initializers.GetDB().Order("fecha")
+
+	if valor := c.Query("anio"); valor != "" {
+		anio, err := strconv.Atoi(valor)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Año inválido")
+			return
+		}
+		query = query.Where("EXTRACT(YEAR FROM fecha) = ?", anio)
+	}
+
+	var festivos []models.DiaFestivo
+	if err := query.Find(&festivos).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener días festivos: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, festivos)
+}
+
+// UpdateDiaFestivo actualiza la fecha o el nombre de un día festivo
+func UpdateDiaFestivo(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var input struct {
+		Fecha  string `json:"fecha"`
+		Nombre string `json:"nombre" binding:"max=100"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var festivo models.DiaFestivo
+	if err := initializers.GetDB().First(&festivo, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Día festivo no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar día festivo: "+err.Error())
+		}
+		return
+	}
+
+	if input.Fecha != "" {
+		fecha, err := time.ParseInLocation("2006-01-02", input.Fecha, time.Local)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Formato de fecha inválido. Use YYYY-MM-DD")
+			return
+		}
+		festivo.Fecha = fecha
+	}
+	if input.Nombre != "" {
+		festivo.Nombre = input.Nombre
+	}
+
+	if err := initializers.GetDB().Save(&festivo).Error; err != nil {
+		if esViolacionUnica(err) {
+			respuestas.RespondError(c, http.StatusConflict, "Ya existe un día festivo en esa fecha")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar día festivo: "+err.Error())
+		}
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, festivo)
+}
+
+// DeleteDiaFestivo elimina un día festivo del calendario
+func DeleteDiaFestivo(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	result := initializers.GetDB().Delete(&models.DiaFestivo{}, id)
+	if result.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al eliminar día festivo: "+result.Error.Error())
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		respuestas.RespondError(c, http.StatusNotFound, "Día festivo no encontrado")
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Día festivo eliminado correctamente"})
+}
+
+// ImportarDiasFestivos carga una lista de días festivos desde un archivo JSON o CSV enviado en el
+// campo "archivo". Las fechas que ya existen actualizan su nombre. Si algún registro es inválido
+// no se importa nada y se devuelven los errores.
+func ImportarDiasFestivos(c *gin.Context) {
+	archivo, err := c.FormFile("archivo")
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "Envíe el archivo en el campo 'archivo'")
+		return
+	}
+
+	formato := strings.TrimPrefix(strings.ToLower(filepath.Ext(archivo.Filename)), ".")
+
+	contenido, err := archivo.Open()
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "No se pudo leer el archivo: "+err.Error())
+		return
+	}
+	defer contenido.Close()
+
+	festivos, errores, err := leerDiasFestivos(formato, contenido)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "Archivo inválido: "+err.Error())
+		return
+	}
+
+	if len(errores) > 0 {
+		respuestas.RespondErrorData(c, http.StatusBadRequest, "El archivo contiene registros inválidos", gin.H{"errores": errores})
+		return
+	}
+
+	if len(festivos) == 0 {
+		respuestas.RespondError(c, http.StatusBadRequest, "El archivo no contiene días festivos")
+		return
+	}
+
+	err = initializers.GetDB().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "fecha"}},
+		DoUpdates: clause.AssignmentColumns([]string{"nombre"}),
+	}).Create(&festivos).Error
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al importar días festivos: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"importados": len(festivos),
+		"dias":       festivos,
+	})
+}
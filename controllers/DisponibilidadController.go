@@ -0,0 +1,335 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Máximo de días que se pueden consultar en una sola petición
+const maxDiasDisponibilidad = 62
+
+// Límites de la duración de slot que se puede pedir con ?duracion=
+const (
+	minDuracionSlot = 5
+	maxDuracionSlot = 480
+)
+
+// Slot representa un espacio reservable en la agenda de un médico
+type Slot struct {
+	Inicio time.Time `json:"inicio"`
+	Fin    time.Time `json:"fin"`
+}
+
+// duracionCitaPorDefecto obtiene la duración de cita de la clínica (variable DURACION_CITA_MINUTOS, 30 por defecto)
+func duracionCitaPorDefecto() time.Duration {
+	if minutos, err := strconv.Atoi(os.Getenv("DURACION_CITA_MINUTOS")); err == nil && minutos > 0 {
+		return time.Duration(minutos) * time.Minute
+	}
+	return 30 * time.Minute
+}
+
+// duracionCitaMedico resuelve la duración por defecto de las citas de un médico:
+// primero la del médico, luego la de su especialidad y por último la de la clínica
+func duracionCitaMedico(db *gorm.DB, medico models.Medico) (time.Duration, error) {
+	if medico.DuracionCitaMinutos != nil && *medico.DuracionCitaMinutos > 0 {
+		return time.Duration(*medico.DuracionCitaMinutos) * time.Minute, nil
+	}
+
+	var config models.ConfiguracionEspecialidad
+	err := db.Where("especialidad = ?", medico.Especialidad).First(&config).Error
+	if err == nil && config.DuracionCitaMinutos > 0 {
+		return time.Duration(config.DuracionCitaMinutos) * time.Minute, nil
+	}
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return 0, err
+	}
+
+	return duracionCitaPorDefecto(), nil
+}
+
+// calcularDisponibilidad expande los horarios del médico en slots de la duración indicada
+// entre desde y hasta (ambos inclusive, por día) y descarta los ocupados por citas activas
+func calcularDisponibilidad(db *gorm.DB, medicoID uint, desde, hasta time.Time, duracion time.Duration) ([]Slot, error) {
+	var horarios []models.Horario
+	if err := db.Where("medico_id = ?", medicoID).Find(&horarios).Error; err != nil {
+		return nil, err
+	}
+
+	finRango := hasta.AddDate(0, 0, 1)
+
+	var citas []models.Cita
+	if err := db.
+		Where("medico_id = ? AND estado IN ?", medicoID, models.EstadosCitaActivos).
+		Where("fecha_cita < ? AND fecha_fin > ?", finRango, desde).
+		Find(&citas).Error; err != nil {
+		return nil, err
+	}
+
+	var bloqueos []models.BloqueoAgenda
+	if err := db.
+		Where("medico_id = ? AND inicio < ? AND fin > ?", medicoID, finRango, desde).
+		Find(&bloqueos).Error; err != nil {
+		return nil, err
+	}
+
+	festivos, err := festivosEnRango(db, desde, hasta)
+	if err != nil {
+		return nil, err
+	}
+
+	ahora := time.Now()
+	slots := []Slot{}
+
+	for dia := desde; dia.Before(finRango); dia = dia.AddDate(0, 0, 1) {
+		if festivos[fechaDia(dia)] {
+			continue
+		}
+
+		nombreDia := models.DiasSemana[dia.Weekday()]
+
+		for _, horario := range horarios {
+			if horario.DiaSemana != nombreDia {
+				continue
+			}
+
+			inicio, fin := horario.Rango(dia)
+			for t := inicio; !t.Add(duracion).After(fin); t = t.Add(duracion) {
+				slot := Slot{Inicio: t, Fin: t.Add(duracion)}
+				if slot.Inicio.Before(ahora) || slotOcupado(slot, citas) || slotBloqueado(slot, bloqueos) {
+					continue
+				}
+				slots = append(slots, slot)
+			}
+		}
+	}
+
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Inicio.Before(slots[j].Inicio) })
+	return slots, nil
+}
+
+// slotOcupado indica si alguna cita se traslapa con el slot
+func slotOcupado(slot Slot, citas []models.Cita) bool {
+	for _, cita := range citas {
+		if cita.FechaCita.Before(slot.Fin) && cita.FechaFin.After(slot.Inicio) {
+			return true
+		}
+	}
+	return false
+}
+
+// slotBloqueado indica si el slot cae en alguna ausencia del médico
+func slotBloqueado(slot Slot, bloqueos []models.BloqueoAgenda) bool {
+	for _, bloqueo := range bloqueos {
+		if bloqueo.Traslapa(slot.Inicio, slot.Fin) {
+			return true
+		}
+	}
+	return false
+}
+
+// bloqueoEnRango devuelve el primer bloqueo del médico que se traslapa con [inicio, fin), o nil
+func bloqueoEnRango(db *gorm.DB, medicoID uint, inicio, fin time.Time) (*models.BloqueoAgenda, error) {
+	var bloqueo models.BloqueoAgenda
+	err := db.Where("medico_id = ? AND inicio < ? AND fin > ?", medicoID, fin, inicio).
+		Order("inicio").
+		First(&bloqueo).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &bloqueo, nil
+}
+
+// Errores de validación de agenda
+var (
+	errFueraDeHorario  = errors.New("La fecha de la cita no está dentro de los horarios del médico")
+	errCitaTraslapada  = errors.New("El médico ya tiene una cita en ese horario")
+	errAgendaBloqueada = errors.New("El médico no está disponible en ese horario")
+)
+
+// validarAgendaCita bloquea la agenda del médico dentro de la transacción y verifica que el
+// intervalo [fecha, fecha+duracion) caiga dentro de uno de sus horarios, fuera de sus ausencias
+// y de los días festivos de la clínica, y no se traslape con otra cita activa (salvo las indicadas en excluirCitaIDs, p. ej. la propia cita al moverla).
+// Si existe un traslape devuelve la cita en conflicto junto con errCitaTraslapada.
+func validarAgendaCita(tx *gorm.DB, medicoID uint, fecha time.Time, duracion time.Duration, excluirCitaIDs ...uint) (*models.Cita, error) {
+	// Bloquear el registro del médico serializa las reservas concurrentes de su agenda
+	var medico models.Medico
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&medico, medicoID).Error; err != nil {
+		return nil, err
+	}
+
+	fecha = fecha.In(time.Local)
+	fin := fecha.Add(duracion)
+
+	festivo, err := diaFestivo(tx, fecha)
+	if err != nil {
+		return nil, err
+	}
+	if festivo != nil {
+		return nil, errDiaFestivo
+	}
+
+	var horarios []models.Horario
+	if err := tx.Where("medico_id = ? AND dia_semana = ?", medicoID, models.DiasSemana[fecha.Weekday()]).Find(&horarios).Error; err != nil {
+		return nil, err
+	}
+
+	dentroDeHorario := false
+	for _, horario := range horarios {
+		inicioHorario, finHorario := horario.Rango(fecha)
+		if !fecha.Before(inicioHorario) && !fin.After(finHorario) {
+			dentroDeHorario = true
+			break
+		}
+	}
+	if !dentroDeHorario {
+		return nil, errFueraDeHorario
+	}
+
+	bloqueo, err := bloqueoEnRango(tx, medicoID, fecha, fin)
+	if err != nil {
+		return nil, err
+	}
+	if bloqueo != nil {
+		return nil, errAgendaBloqueada
+	}
+
+	query := tx.
+		Where("medico_id = ? AND estado IN ?", medicoID, models.EstadosCitaActivos).
+		Where("fecha_cita < ? AND fecha_fin > ?", fin, fecha)
+	if len(excluirCitaIDs) > 0 {
+		query = query.Where("id NOT IN ?", excluirCitaIDs)
+	}
+
+	var conflicto models.Cita
+	err = query.Order("fecha_cita").First(&conflicto).Error
+	if err == nil {
+		return &conflicto, errCitaTraslapada
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// esViolacionDeAgenda detecta las violaciones de las restricciones de agenda en Postgres
+// (índice único o exclusión), que pueden ocurrir si dos reservas compiten por el mismo horario
+func esViolacionDeAgenda(err error) bool {
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		return pgErr.SQLState() == "23505" || pgErr.SQLState() == "23P01"
+	}
+	return false
+}
+
+// esViolacionUnica detecta solo las violaciones de un índice único en Postgres
+func esViolacionUnica(err error) bool {
+	var pgErr interface{ SQLState() string }
+	return errors.As(err, &pgErr) && pgErr.SQLState() == "23505"
+}
+
+// responderErrorAgenda traduce los errores de validarAgendaCita a la respuesta HTTP
+func responderErrorAgenda(c *gin.Context, conflicto *models.Cita, err error) {
+	switch {
+	case errors.Is(err, errCitaTraslapada):
+		respuestas.RespondErrorData(c, http.StatusConflict, err.Error(), gin.H{"cita_conflicto": conflicto})
+	case errors.Is(err, errFueraDeHorario), errors.Is(err, errAgendaBloqueada), errors.Is(err, errDiaFestivo):
+		respuestas.RespondError(c, http.StatusConflict, err.Error())
+	case err == gorm.ErrRecordNotFound:
+		respuestas.RespondError(c, http.StatusBadRequest, "Médico no encontrado")
+	default:
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al validar agenda: "+err.Error())
+	}
+}
+
+// GetDisponibilidadMedico devuelve los slots libres de un médico en un rango de fechas
+func GetDisponibilidadMedico(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID de médico inválido")
+		return
+	}
+
+	var medico models.Medico
+	if err := initializers.GetDB().First(&medico, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Médico no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar médico: "+err.Error())
+		}
+		return
+	}
+
+	// Formato esperado: YYYY-MM-DD, por defecto de hoy a 7 días
+	hoy := time.Now()
+	desde := time.Date(hoy.Year(), hoy.Month(), hoy.Day(), 0, 0, 0, 0, time.Local)
+	if valor := c.Query("desde"); valor != "" {
+		desde, err = time.ParseInLocation("2006-01-02", valor, time.Local)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Formato de fecha 'desde' inválido. Use YYYY-MM-DD")
+			return
+		}
+	}
+
+	hasta := desde.AddDate(0, 0, 7)
+	if valor := c.Query("hasta"); valor != "" {
+		hasta, err = time.ParseInLocation("2006-01-02", valor, time.Local)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Formato de fecha 'hasta' inválido. Use YYYY-MM-DD")
+			return
+		}
+	}
+
+	if hasta.Before(desde) {
+		respuestas.RespondError(c, http.StatusBadRequest, "La fecha 'hasta' debe ser igual o posterior a 'desde'")
+		return
+	}
+
+	if hasta.Sub(desde) > maxDiasDisponibilidad*24*time.Hour {
+		respuestas.RespondError(c, http.StatusBadRequest, "El rango máximo de consulta es de "+strconv.Itoa(maxDiasDisponibilidad)+" días")
+		return
+	}
+
+	duracion, err := duracionCitaMedico(initializers.GetDB(), medico)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener duración de citas: "+err.Error())
+		return
+	}
+	if valor := c.Query("duracion"); valor != "" {
+		minutos, err := strconv.Atoi(valor)
+		if err != nil || minutos < minDuracionSlot || minutos > maxDuracionSlot {
+			respuestas.RespondError(c, http.StatusBadRequest, "La duración debe estar entre "+strconv.Itoa(minDuracionSlot)+" y "+strconv.Itoa(maxDuracionSlot)+" minutos")
+			return
+		}
+		duracion = time.Duration(minutos) * time.Minute
+	}
+
+	slots, err := calcularDisponibilidad(initializers.GetDB(), medico.ID, desde, hasta, duracion)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al calcular disponibilidad: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"medico_id":        medico.ID,
+		"desde":            desde.Format("2006-01-02"),
+		"hasta":            hasta.Format("2006-01-02"),
+		"duracion_minutos": int(duracion.Minutes()),
+		"slots":            slots,
+	})
+}
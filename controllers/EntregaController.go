@@ -0,0 +1,309 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/canales"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+	"github.com/Ilimm9/CMedicas/paginacion"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Canales activos para un usuario sin preferencias registradas. El webhook es de la clínica y no
+// depende del usuario.
+var canalesPorDefecto = map[string]bool{
+	canales.Email:    true,
+	canales.SMS:      false,
+	canales.WhatsApp: false,
+	canales.Webhook:  true,
+}
+
+// Canales que cada usuario puede activar o desactivar
+var canalesElegibles = []string{canales.Email, canales.SMS, canales.WhatsApp}
+
+var errCanalNoConfigurado = errors.New("El canal no está configurado")
+
+// canalesUsuario combina los valores por defecto con las preferencias del usuario
+func canalesUsuario(db *gorm.DB, usuarioID uint) (map[string]bool, error) {
+	activos := map[string]bool{}
+	for canal, activo := range canalesPorDefecto {
+		activos[canal] = activo
+	}
+
+	var preferencias []models.CanalUsuario
+	if err := db.Where("usuario_id = ?", usuarioID).Find(&preferencias).Error; err != nil {
+		return nil, err
+	}
+	for _, preferencia := range preferencias {
+		activos[preferencia.Canal] = preferencia.Activo
+	}
+	return activos, nil
+}
+
+// destinatarioUsuario arma los datos de contacto del usuario para los canales
+func destinatarioUsuario(usuario models.Usuario) canales.Destinatario {
+	return canales.Destinatario{
+		UsuarioID: usuario.ID,
+		Nombre:    usuario.Persona.NombreCompleto(),
+		Correo:    usuario.Correo,
+		Telefono:  usuario.Persona.Telefono,
+	}
+}
+
+// despacharNotificacion atiende el evento notificacion.creada: crea una entrega pendiente por cada
+// canal activo del destinatario y registra en el outbox el envío de cada una
+func despacharNotificacion(tx *gorm.DB, evento models.EventoOutbox) error {
+	var notificacion models.Notificacion
+	err := tx.First(&notificacion, evento.Referencia).Error
+	if err == gorm.ErrRecordNotFound {
+		// Se eliminó antes de despacharse
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var usuario models.Usuario
+	if err := tx.Preload("Persona").First(&usuario, notificacion.IDUsuario).Error; err != nil {
+		return err
+	}
+
+	activos, err := canalesUsuario(tx, usuario.ID)
+	if err != nil {
+		return err
+	}
+
+	ahora := time.Now()
+	destinatario := destinatarioUsuario(usuario)
+	for nombre, canal := range canales.Registrados() {
+		destino := canal.Destino(destinatario)
+		if !activos[nombre] || destino == "" {
+			continue
+		}
+
+		entrega := models.EntregaNotificacion{
+			NotificacionID: notificacion.ID,
+			Canal:          nombre,
+			Destino:        destino,
+			Estado:         models.EntregaPendiente,
+			ProximoIntento: ahora,
+		}
+		resultado := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&entrega)
+		if resultado.Error != nil {
+			return resultado.Error
+		}
+		// Ya existía: el evento se está reprocesando
+		if resultado.RowsAffected == 0 {
+			continue
+		}
+
+		if err := models.RegistrarEvento(tx, models.EventoEntregaEnviar, entrega.ID, map[string]interface{}{
+			"entrega_id":      entrega.ID,
+			"notificacion_id": notificacion.ID,
+			"canal":           nombre,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(&notificacion).Update("despachada_en", ahora).Error
+}
+
+// enviarEntrega atiende el evento entrega.enviar: entrega la notificación por el canal. Si el
+// canal falla se devuelve el error para que el outbox la reintente.
+func enviarEntrega(tx *gorm.DB, evento models.EventoOutbox) error {
+	var entrega models.EntregaNotificacion
+	err := tx.Preload("Notificacion").First(&entrega, evento.Referencia).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if entrega.Estado == models.EntregaEnviada {
+		return nil
+	}
+
+	canal, ok := canales.Registrados()[entrega.Canal]
+	if !ok {
+		return errCanalNoConfigurado
+	}
+
+	notificacion := entrega.Notificacion
+	texto, err := textoEntrega(tx, entrega.Canal, notificacion)
+	if err != nil {
+		return err
+	}
+	if err := canal.Enviar(entrega.Destino, canales.Mensaje{
+		Clave:          "entrega-" + strconv.FormatUint(uint64(entrega.ID), 10),
+		NotificacionID: notificacion.ID,
+		UsuarioID:      notificacion.IDUsuario,
+		CitaID:         notificacion.CitaID,
+		Tipo:           notificacion.Tipo,
+		Texto:          texto,
+		Fecha:          notificacion.FechaEnvio,
+	}); err != nil {
+		return err
+	}
+
+	// Sin la notificación precargada: GORM la volvería a guardar y su AfterCreate duplicaría el evento
+	ahora := time.Now()
+	return tx.Model(&models.EntregaNotificacion{}).Where("id = ?", entrega.ID).Updates(map[string]interface{}{
+		"estado":       models.EntregaEnviada,
+		"intentos":     evento.Intentos,
+		"ultimo_error": "",
+		"enviada_en":   ahora,
+	}).Error
+}
+
+// falloEntrega refleja en la entrega el intento fallido del evento entrega.enviar
+func falloEntrega(tx *gorm.DB, evento models.EventoOutbox) error {
+	estado := models.EntregaPendiente
+	if evento.Estado == models.OutboxFallido {
+		estado = models.EntregaFallida
+	}
+
+	return tx.Model(&models.EntregaNotificacion{}).Where("id = ?", evento.Referencia).Updates(map[string]interface{}{
+		"estado":          estado,
+		"intentos":        evento.Intentos,
+		"ultimo_error":    evento.UltimoError,
+		"proximo_intento": evento.ProximoIntento,
+	}).Error
+}
+
+// GetCanalesUsuarioActual muestra qué canales recibe el usuario actual, si están disponibles en
+// la clínica y a qué dirección se envían
+func GetCanalesUsuarioActual(c *gin.Context) {
+	db := initializers.GetDB()
+
+	var usuario models.Usuario
+	if err := db.Preload("Persona").First(&usuario, c.GetUint("userID")).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar usuario: "+err.Error())
+		return
+	}
+
+	activos, err := canalesUsuario(db, usuario.ID)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener canales: "+err.Error())
+		return
+	}
+
+	registrados := canales.Registrados()
+	destinatario := destinatarioUsuario(usuario)
+
+	resultado := []gin.H{}
+	for _, nombre := range canalesElegibles {
+		destino := ""
+		canal, disponible := registrados[nombre]
+		if disponible {
+			destino = canal.Destino(destinatario)
+		}
+		resultado = append(resultado, gin.H{
+			"canal":      nombre,
+			"activo":     activos[nombre],
+			"disponible": disponible,
+			"destino":    destino,
+		})
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, resultado)
+}
+
+// UpdateCanalesUsuarioActual activa o desactiva los canales del usuario actual,
+// p. ej. {"email": true, "whatsapp": false}
+func UpdateCanalesUsuarioActual(c *gin.Context) {
+	var input map[string]bool
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	elegibles := map[string]bool{}
+	for _, nombre := range canalesElegibles {
+		elegibles[nombre] = true
+	}
+	for nombre := range input {
+		if !elegibles[nombre] {
+			respuestas.RespondError(c, http.StatusBadRequest, "Canal inválido: "+nombre+". Use email, sms o whatsapp")
+			return
+		}
+	}
+
+	db := initializers.GetDB()
+	userID := c.GetUint("userID")
+
+	for nombre, activo := range input {
+		preferencia := models.CanalUsuario{UsuarioID: userID, Canal: nombre, Activo: activo}
+		if err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "usuario_id"}, {Name: "canal"}},
+			DoUpdates: clause.AssignmentColumns([]string{"activo"}),
+		}).Create(&preferencia).Error; err != nil {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar preferencia: "+err.Error())
+			return
+		}
+	}
+
+	GetCanalesUsuarioActual(c)
+}
+
+// GetAllEntregas lista las entregas de notificaciones con su estado e intentos
+func GetAllEntregas(c *gin.Context) {
+	var entregas []models.EntregaNotificacion
+	meta, err := paginacion.Paginar(c, initializers.GetDB(), listadoEntregas, &entregas)
+	if err != nil {
+		responderErrorListado(c, "Error al obtener entregas: ", err)
+		return
+	}
+
+	respuestas.RespondSuccessPaginado(c, http.StatusOK, entregas, meta)
+}
+
+// Parámetros admitidos por el listado de entregas
+var listadoEntregas = paginacion.Listado[models.EntregaNotificacion]{
+	Tabla: "entrega_notificacions",
+	ID:    func(entrega models.EntregaNotificacion) uint { return entrega.ID },
+	Orden: map[string]paginacion.Orden[models.EntregaNotificacion]{
+		"id":        {Columna: "entrega_notificacions.id", Valor: func(entrega models.EntregaNotificacion) any { return entrega.ID }},
+		"creada_en": {Columna: "entrega_notificacions.creada_en", Valor: func(entrega models.EntregaNotificacion) any { return entrega.CreadaEn }},
+	},
+	OrdenPorDefecto: "-id",
+	Filtros: map[string]string{
+		"estado": "entrega_notificacions.estado",
+		"canal":  "entrega_notificacions.canal",
+	},
+	FiltrosNumericos: map[string]string{
+		"notificacion_id": "entrega_notificacions.notificacion_id",
+	},
+	CampoFecha: "entrega_notificacions.creada_en",
+}
+
+// GetEntregasNotificacion devuelve el estado de entrega de una notificación en cada canal
+func GetEntregasNotificacion(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var notificacion models.Notificacion
+	if err := initializers.GetDB().
+		Preload("Entregas", func(db *gorm.DB) *gorm.DB { return db.Order("canal") }).
+		First(&notificacion, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Notificación no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar notificación: "+err.Error())
+		}
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, notificacion)
+}
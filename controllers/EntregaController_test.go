@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/canales"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// canalFalso registra los mensajes en lugar de enviarlos
+type canalFalso struct {
+	nombre   string
+	err      error
+	enviados []canales.Mensaje
+	destinos []string
+}
+
+func (f *canalFalso) Nombre() string {
+	return f.nombre
+}
+
+func (f *canalFalso) Destino(d canales.Destinatario) string {
+	if f.nombre == canales.SMS {
+		return d.Telefono
+	}
+	return d.Correo
+}
+
+func (f *canalFalso) Enviar(destino string, m canales.Mensaje) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.destinos = append(f.destinos, destino)
+	f.enviados = append(f.enviados, m)
+	return nil
+}
+
+// configurarCanales reemplaza los canales durante la prueba
+func configurarCanales(t *testing.T, lista ...canales.Canal) {
+	t.Helper()
+	canales.Configurar(lista...)
+	t.Cleanup(func() { canales.Configurar() })
+}
+
+// baseDatosSimulada abre GORM sobre una conexión simulada que valida las consultas esperadas
+func baseDatosSimulada(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	conexion, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conexion}), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		conexion.Close()
+	})
+	return db, mock
+}
+
+func TestDespacharNotificacion(t *testing.T) {
+	email := &canalFalso{nombre: canales.Email}
+	sms := &canalFalso{nombre: canales.SMS}
+	configurarCanales(t, email, sms)
+
+	db, mock := baseDatosSimulada(t)
+	mock.ExpectQuery(`SELECT \* FROM "notificacions" WHERE "notificacions"."id" = \$1`).
+		WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "id_usuario", "cita_id", "tipo", "mensaje"}).
+			AddRow(5, 3, 9, "recordatorio", "Tiene una cita mañana"))
+	mock.ExpectQuery(`SELECT \* FROM "usuarios" WHERE "usuarios"."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "persona_id", "correo"}).AddRow(3, 4, "paciente@ejemplo.com"))
+	mock.ExpectQuery(`SELECT \* FROM "personas" WHERE "personas"."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "nombre", "telefono"}).AddRow(4, "Ana", "5551234567"))
+	// Sin preferencias: correo activo y SMS inactivo por defecto
+	mock.ExpectQuery(`SELECT \* FROM "canal_usuarios" WHERE usuario_id = \$1`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "usuario_id", "canal", "activo"}))
+	mock.ExpectQuery(`INSERT INTO "entrega_notificacions" .* ON CONFLICT DO NOTHING`).
+		WithArgs(5, canales.Email, "paciente@ejemplo.com", models.EntregaPendiente, 0, "", sqlmock.AnyArg(), nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	mock.ExpectQuery(`INSERT INTO "evento_outboxes"`).
+		WithArgs(models.EventoEntregaEnviar, 11, sqlmock.AnyArg(), models.OutboxPendiente, 0, "", sqlmock.AnyArg(), nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(21))
+	mock.ExpectExec(`SELECT pg_notify`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "notificacions" SET "despachada_en"=\$1 WHERE "id" = \$2`).
+		WithArgs(sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	evento := models.EventoOutbox{Tipo: models.EventoNotificacionCreada, Referencia: 5}
+	if err := despacharNotificacion(db, evento); err != nil {
+		t.Fatal(err)
+	}
+
+	// Despachar solo programa las entregas; el envío es otro evento
+	if len(email.enviados) > 0 || len(sms.enviados) > 0 {
+		t.Error("despachar no debe enviar por los canales")
+	}
+}
+
+func TestDespacharNotificacionEliminada(t *testing.T) {
+	configurarCanales(t, &canalFalso{nombre: canales.Email})
+
+	db, mock := baseDatosSimulada(t)
+	mock.ExpectQuery(`SELECT \* FROM "notificacions"`).WillReturnError(gorm.ErrRecordNotFound)
+
+	if err := despacharNotificacion(db, models.EventoOutbox{Referencia: 5}); err != nil {
+		t.Errorf("una notificación eliminada no debe reintentarse: %v", err)
+	}
+}
+
+// esperarEntrega simula la lectura de la entrega 11 por el canal indicado, con su notificación
+func esperarEntrega(mock sqlmock.Sqlmock, canal, estado string) {
+	esperarEntregaConSala(mock, canal, estado, false)
+}
+
+// esperarEntregaConSala es esperarEntrega con una notificación que puede llevar el enlace de videollamada
+func esperarEntregaConSala(mock sqlmock.Sqlmock, canal, estado string, conSala bool) {
+	mock.ExpectQuery(`SELECT \* FROM "entrega_notificacions" WHERE "entrega_notificacions"."id" = \$1`).
+		WithArgs(11, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "notificacion_id", "canal", "destino", "estado"}).
+			AddRow(11, 5, canal, "paciente@ejemplo.com", estado))
+	mock.ExpectQuery(`SELECT \* FROM "notificacions" WHERE "notificacions"."id" = \$1`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "id_usuario", "cita_id", "tipo", "mensaje", "fecha_envio", "con_sala"}).
+			AddRow(5, 3, 9, "recordatorio", "Tiene una cita mañana", time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC), conSala))
+}
+
+func TestEnviarEntrega(t *testing.T) {
+	email := &canalFalso{nombre: canales.Email}
+	configurarCanales(t, email)
+
+	db, mock := baseDatosSimulada(t)
+	esperarEntrega(mock, canales.Email, models.EntregaPendiente)
+	// Solo se actualiza la entrega, sin volver a guardar la notificación precargada
+	mock.ExpectExec(`UPDATE "entrega_notificacions" SET .* WHERE id = \$\d+$`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := enviarEntrega(db, models.EventoOutbox{Tipo: models.EventoEntregaEnviar, Referencia: 11, Intentos: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(email.enviados) != 1 {
+		t.Fatalf("se enviaron %d mensajes, se esperaba 1", len(email.enviados))
+	}
+	m := email.enviados[0]
+	if email.destinos[0] != "paciente@ejemplo.com" {
+		t.Errorf("destino inesperado: %s", email.destinos[0])
+	}
+	// La clave se repite en los reintentos para que el receptor descarte duplicados
+	if m.Clave != "entrega-11" || m.NotificacionID != 5 || m.UsuarioID != 3 || m.CitaID != 9 || m.Texto != "Tiene una cita mañana" {
+		t.Errorf("mensaje inesperado: %+v", m)
+	}
+}
+
+func TestEnviarEntregaConSala(t *testing.T) {
+	email := &canalFalso{nombre: canales.Email}
+	webhook := &canalFalso{nombre: canales.Webhook}
+	configurarCanales(t, email, webhook)
+
+	db, mock := baseDatosSimulada(t)
+	esperarEntregaConSala(mock, canales.Email, models.EntregaPendiente, true)
+	mock.ExpectQuery(`SELECT \* FROM "sala_virtuals" WHERE cita_id = \$1`).
+		WithArgs(9, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "cita_id", "url"}).AddRow(2, 9, "https://meet.ejemplo.com/sala"))
+	mock.ExpectExec(`UPDATE "entrega_notificacions"`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := enviarEntrega(db, models.EventoOutbox{Referencia: 11}); err != nil {
+		t.Fatal(err)
+	}
+	if len(email.enviados) != 1 || email.enviados[0].Texto != "Tiene una cita mañana. Enlace de videollamada: https://meet.ejemplo.com/sala" {
+		t.Errorf("el correo del paciente debe llevar el enlace: %+v", email.enviados)
+	}
+
+	// El webhook de la clínica recibe el mensaje sin el enlace y sin consultar la sala
+	esperarEntregaConSala(mock, canales.Webhook, models.EntregaPendiente, true)
+	mock.ExpectExec(`UPDATE "entrega_notificacions"`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := enviarEntrega(db, models.EventoOutbox{Referencia: 11}); err != nil {
+		t.Fatal(err)
+	}
+	if len(webhook.enviados) != 1 || webhook.enviados[0].Texto != "Tiene una cita mañana" {
+		t.Errorf("el webhook no debe recibir el enlace: %+v", webhook.enviados)
+	}
+}
+
+func TestEnviarEntregaFallaElCanal(t *testing.T) {
+	errCanal := errors.New("servidor no disponible")
+	configurarCanales(t, &canalFalso{nombre: canales.Email, err: errCanal})
+
+	db, mock := baseDatosSimulada(t)
+	esperarEntrega(mock, canales.Email, models.EntregaPendiente)
+
+	// El error vuelve al outbox para reintentar; la entrega no se marca como enviada
+	if err := enviarEntrega(db, models.EventoOutbox{Referencia: 11, Intentos: 1}); !errors.Is(err, errCanal) {
+		t.Errorf("se esperaba el error del canal, se obtuvo %v", err)
+	}
+}
+
+func TestEnviarEntregaCanalNoConfigurado(t *testing.T) {
+	configurarCanales(t)
+
+	db, mock := baseDatosSimulada(t)
+	esperarEntrega(mock, canales.WhatsApp, models.EntregaPendiente)
+
+	if err := enviarEntrega(db, models.EventoOutbox{Referencia: 11}); !errors.Is(err, errCanalNoConfigurado) {
+		t.Errorf("se esperaba errCanalNoConfigurado, se obtuvo %v", err)
+	}
+}
+
+func TestEnviarEntregaYaEnviada(t *testing.T) {
+	email := &canalFalso{nombre: canales.Email}
+	configurarCanales(t, email)
+
+	db, mock := baseDatosSimulada(t)
+	esperarEntrega(mock, canales.Email, models.EntregaEnviada)
+
+	if err := enviarEntrega(db, models.EventoOutbox{Referencia: 11}); err != nil {
+		t.Fatal(err)
+	}
+	if len(email.enviados) > 0 {
+		t.Error("una entrega ya enviada no debe repetirse")
+	}
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ConfiguracionEspecialidadInput struct {
+	Especialidad        string `json:"especialidad" binding:"required,max=100"`
+	DuracionCitaMinutos int    `json:"duracion_cita_minutos" binding:"required,min=5,max=480"`
+}
+
+// PostConfiguracionEspecialidad crea la configuración de agenda de una especialidad
+func PostConfiguracionEspecialidad(c *gin.Context) {
+	var input ConfiguracionEspecialidadInput
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var count int64
+	if err := initializers.GetDB().Model(&models.ConfiguracionEspecialidad{}).Where("especialidad = ?", input.Especialidad).Count(&count).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar especialidad: "+err.Error())
+		return
+	}
+
+	if count > 0 {
+		respuestas.RespondError(c, http.StatusConflict, "La especialidad ya tiene configuración")
+		return
+	}
+
+	config := models.ConfiguracionEspecialidad{
+		Especialidad:        input.Especialidad,
+		DuracionCitaMinutos: input.DuracionCitaMinutos,
+	}
+
+	if err := initializers.GetDB().Create(&config).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar configuración: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, config)
+}
+
+// GetAllConfiguracionesEspecialidad obtiene la configuración de todas las especialidades
+func GetAllConfiguracionesEspecialidad(c *gin.Context) {
+	var configs []models.ConfiguracionEspecialidad
+	if err := initializers.GetDB().Order("especialidad").Find(&configs).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener configuraciones: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, configs)
+}
+
+// UpdateConfiguracionEspecialidad actualiza la configuración de una especialidad
+func UpdateConfiguracionEspecialidad(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var input struct {
+		DuracionCitaMinutos int `json:"duracion_cita_minutos" binding:"required,min=5,max=480"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var config models.ConfiguracionEspecialidad
+	if err := initializers.GetDB().First(&config, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Configuración no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar configuración: "+err.Error())
+		}
+		return
+	}
+
+	config.DuracionCitaMinutos = input.DuracionCitaMinutos
+	if err := initializers.GetDB().Save(&config).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar configuración: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, config)
+}
+
+// DeleteConfiguracionEspecialidad elimina la configuración de una especialidad
+func DeleteConfiguracionEspecialidad(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	result := initializers.GetDB().Delete(&models.ConfiguracionEspecialidad{}, id)
+	if result.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al eliminar configuración: "+result.Error.Error())
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		respuestas.RespondError(c, http.StatusNotFound, "Configuración no encontrada")
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Configuración eliminada correctamente"})
+}
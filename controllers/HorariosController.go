@@ -8,6 +8,7 @@ import (
 	"github.com/Ilimm9/CMedicas/Respuestas"
 	"github.com/Ilimm9/CMedicas/initializers"
 	"github.com/Ilimm9/CMedicas/models"
+	"github.com/Ilimm9/CMedicas/paginacion"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -113,23 +114,36 @@ func GetHorario(c *gin.Context) {
 // GetAllHorarios obtiene todos los horarios
 func GetAllHorarios(c *gin.Context) {
 	var horarios []models.Horario
-	result := initializers.GetDB().
-		Preload("Medico").
-		Preload("Medico.Usuario").
-		Preload("Medico.Usuario.Persona").
-		Find(&horarios)
-
-	if result.Error != nil {
-		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener horarios: "+result.Error.Error())
+	meta, err := paginacion.Paginar(c, initializers.GetDB(), listadoHorarios, &horarios)
+	if err != nil {
+		responderErrorListado(c, "Error al obtener horarios: ", err)
 		return
 	}
 
-	respuestas.RespondSuccess(c, http.StatusOK, horarios)
+	respuestas.RespondSuccessPaginado(c, http.StatusOK, horarios, meta)
+}
+
+// Parámetros admitidos por el listado de horarios
+var listadoHorarios = paginacion.Listado[models.Horario]{
+	Tabla: "horarios",
+	ID:    func(horario models.Horario) uint { return horario.ID },
+	Orden: map[string]paginacion.Orden[models.Horario]{
+		"id":        {Columna: "horarios.id", Valor: func(horario models.Horario) any { return horario.ID }},
+		"medico_id": {Columna: "horarios.medico_id", Valor: func(horario models.Horario) any { return horario.MedicoID }},
+	},
+	OrdenPorDefecto: "id",
+	Filtros: map[string]string{
+		"dia_semana": "horarios.dia_semana",
+	},
+	FiltrosNumericos: map[string]string{
+		"medico_id": "horarios.medico_id",
+	},
+	Preload: []string{"Medico", "Medico.Usuario", "Medico.Usuario.Persona"},
 }
 
 // GetHorariosPorMedico obtiene los horarios de un médico específico
 func GetHorariosPorMedico(c *gin.Context) {
-	medicoID, err := strconv.Atoi(c.Param("medico_id"))
+	medicoID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		respuestas.RespondError(c, http.StatusBadRequest, "ID de médico inválido")
 		return
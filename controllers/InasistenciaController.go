@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Acciones de la regla de inasistencias al superar el límite
+const (
+	accionInasistenciasAprobacion = "aprobacion"
+	accionInasistenciasBloquear   = "bloquear"
+)
+
+// Código de error cuando el paciente supera el límite de inasistencias
+const codigoInasistenciasExcedidas = "INASISTENCIAS_EXCEDIDAS"
+
+// ReglaInasistencias restringe las nuevas citas de pacientes con muchas inasistencias recientes
+type ReglaInasistencias struct {
+	Limite      int    `json:"limite"` // 0 desactiva la regla
+	VentanaDias int    `json:"ventana_dias"`
+	Accion      string `json:"accion"` // aprobacion o bloquear
+}
+
+// enteroEnv lee una variable de entorno entera no negativa, con valor por defecto
+func enteroEnv(nombre string, porDefecto int) int {
+	if valor, err := strconv.Atoi(os.Getenv(nombre)); err == nil && valor >= 0 {
+		return valor
+	}
+	return porDefecto
+}
+
+// graciaInasistencia es el tiempo tras el inicio de la cita para marcarla como no asistida
+// (variable INASISTENCIA_GRACIA_MINUTOS, 30 por defecto)
+func graciaInasistencia() time.Duration {
+	return time.Duration(enteroEnv("INASISTENCIA_GRACIA_MINUTOS", 30)) * time.Minute
+}
+
+// reglaInasistencias lee la regla de las variables INASISTENCIAS_LIMITE (3), INASISTENCIAS_VENTANA_DIAS (90)
+// e INASISTENCIAS_ACCION (aprobacion o bloquear)
+func reglaInasistencias() ReglaInasistencias {
+	regla := ReglaInasistencias{
+		Limite:      enteroEnv("INASISTENCIAS_LIMITE", 3),
+		VentanaDias: enteroEnv("INASISTENCIAS_VENTANA_DIAS", 90),
+		Accion:      accionInasistenciasAprobacion,
+	}
+	if os.Getenv("INASISTENCIAS_ACCION") == accionInasistenciasBloquear {
+		regla.Accion = accionInasistenciasBloquear
+	}
+	return regla
+}
+
+// inasistenciasRecientes cuenta las inasistencias del paciente dentro de la ventana de la regla
+func inasistenciasRecientes(db *gorm.DB, pacienteID uint, regla ReglaInasistencias) (int64, error) {
+	var count int64
+	err := db.Model(&models.Cita{}).
+		Where("paciente_id = ? AND estado = ? AND fecha_cita >= ?", pacienteID, models.EstadoNoAsistio, time.Now().AddDate(0, 0, -regla.VentanaDias)).
+		Count(&count).Error
+	return count, err
+}
+
+// estadoInicialCita aplica la regla de inasistencias a una nueva cita del paciente: devuelve el
+// estado con el que debe crearse (programada, o por_aprobar si requiere aprobación). Los
+// administradores no están sujetos a la regla. Si la cita se bloquea responde el error y devuelve false.
+func estadoInicialCita(c *gin.Context, db *gorm.DB, pacienteID uint) (string, bool) {
+	regla := reglaInasistencias()
+	if regla.Limite == 0 || c.GetString("userRol") == "administrador" {
+		return models.EstadoProgramada, true
+	}
+
+	recientes, err := inasistenciasRecientes(db, pacienteID, regla)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar inasistencias: "+err.Error())
+		return "", false
+	}
+
+	if recientes < int64(regla.Limite) {
+		return models.EstadoProgramada, true
+	}
+
+	if regla.Accion == accionInasistenciasBloquear {
+		respuestas.RespondErrorCodigo(c, http.StatusForbidden, codigoInasistenciasExcedidas,
+			"El paciente superó el límite de inasistencias, solo un administrador puede agendarle citas")
+		return "", false
+	}
+
+	return models.EstadoPorAprobar, true
+}
+
+// marcarInasistencias pasa a no_asistio las citas pendientes cuyo inicio pasó hace más que el
+// periodo de gracia y notifica al paciente. Cada cita se procesa en su propia transacción.
+func marcarInasistencias() (int, error) {
+	limite := time.Now().Add(-graciaInasistencia())
+	marcadas := 0
+	for {
+		procesada := false
+		err := initializers.GetDB().Transaction(func(tx *gorm.DB) error {
+			var cita models.Cita
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("estado IN ? AND fecha_cita < ?", models.EstadosCitaPendientes, limite).
+				Order("fecha_cita").
+				First(&cita).Error
+			if err == gorm.ErrRecordNotFound {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			procesada = true
+			if err := cambiarEstadoCita(tx, &cita, models.EstadoNoAsistio, models.RolSistema, 0, "Sin registro de llegada tras el periodo de gracia"); err != nil {
+				return err
+			}
+
+			notificacion := models.Notificacion{
+				IDUsuario:  cita.PacienteID,
+				CitaID:     cita.ID,
+				Tipo:       "aviso",
+				Mensaje:    "Se registró su inasistencia a la cita del " + fechaLegible(cita.FechaCita),
+				FechaEnvio: time.Now(),
+			}
+			return tx.Create(&notificacion).Error
+		})
+		if err != nil || !procesada {
+			return marcadas, err
+		}
+		marcadas++
+	}
+}
+
+// ProcesarInasistencias marca periódicamente las citas no asistidas. Se ejecuta en segundo plano.
+func ProcesarInasistencias(intervalo time.Duration) {
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if n, err := marcarInasistencias(); err != nil {
+			log.Println("Error al marcar inasistencias:", err)
+		} else if n > 0 {
+			log.Printf("Citas marcadas como no asistidas: %d", n)
+		}
+	}
+}
+
+// MarcarInasistencias ejecuta de inmediato el marcado de citas no asistidas
+func MarcarInasistencias(c *gin.Context) {
+	marcadas, err := marcarInasistencias()
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al marcar inasistencias: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"marcadas": marcadas})
+}
+
+// GetInasistenciasPaciente obtiene el contador de inasistencias de un paciente, las recientes
+// según la regla vigente y si sus nuevas citas están restringidas
+func GetInasistenciasPaciente(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var paciente models.Usuario
+	if err := initializers.GetDB().First(&paciente, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Usuario no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar usuario: "+err.Error())
+		}
+		return
+	}
+
+	regla := reglaInasistencias()
+	recientes, err := inasistenciasRecientes(initializers.GetDB(), paciente.ID, regla)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al contar inasistencias: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"paciente_id":   paciente.ID,
+		"inasistencias": paciente.Inasistencias,
+		"recientes":     recientes,
+		"regla":         regla,
+		"restringido":   regla.Limite > 0 && recientes >= int64(regla.Limite),
+	})
+}
+
+// AprobarCita programa una cita que quedó pendiente de aprobación por la regla de inasistencias
+func AprobarCita(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	var cita models.Cita
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&cita, id).Error; err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Cita no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar cita: "+err.Error())
+		}
+		return
+	}
+
+	if cita.Estado != models.EstadoPorAprobar {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusConflict, "La cita no está pendiente de aprobación")
+		return
+	}
+
+	if err := cambiarEstadoCita(tx, &cita, models.EstadoProgramada, c.GetString("userRol"), c.GetUint("userID"), "Aprobada por administración"); err != nil {
+		tx.Rollback()
+		responderErrorTransicion(c, err)
+		return
+	}
+
+	conSala, err := conSalaVirtual(tx, cita)
+	if err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener sala virtual: "+err.Error())
+		return
+	}
+
+	notificacion := models.Notificacion{
+		IDUsuario:  cita.PacienteID,
+		CitaID:     cita.ID,
+		Tipo:       "confirmación",
+		Mensaje:    "Su cita del " + fechaLegible(cita.FechaCita) + " fue aprobada",
+		FechaEnvio: time.Now(),
+		ConSala:    conSala,
+	}
+
+	if err := tx.Create(&notificacion).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear notificación: "+err.Error())
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, cita)
+}
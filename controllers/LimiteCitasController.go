@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Códigos de error al superar los límites de citas activas
+const (
+	codigoLimiteCitasPaciente     = "LIMITE_CITAS_PACIENTE"
+	codigoLimiteCitasMedico       = "LIMITE_CITAS_MEDICO"
+	codigoLimiteCitasEspecialidad = "LIMITE_CITAS_ESPECIALIDAD"
+)
+
+// LimitesCitas es el máximo de citas activas que un paciente puede tener a la vez; 0 = sin límite
+type LimitesCitas struct {
+	Total           int `json:"total"`
+	PorMedico       int `json:"por_medico"`
+	PorEspecialidad int `json:"por_especialidad"`
+}
+
+// CitasActivasPaciente es el uso actual de un paciente frente a los límites
+type CitasActivasPaciente struct {
+	Total           int64            `json:"total"`
+	PorMedico       map[uint]int64   `json:"por_medico"`
+	PorEspecialidad map[string]int64 `json:"por_especialidad"`
+}
+
+// limitesCitas lee los límites de CITAS_ACTIVAS_MAX, CITAS_ACTIVAS_MAX_MEDICO y
+// CITAS_ACTIVAS_MAX_ESPECIALIDAD (sin límite por defecto)
+func limitesCitas() LimitesCitas {
+	return LimitesCitas{
+		Total:           enteroEnv("CITAS_ACTIVAS_MAX", 0),
+		PorMedico:       enteroEnv("CITAS_ACTIVAS_MAX_MEDICO", 0),
+		PorEspecialidad: enteroEnv("CITAS_ACTIVAS_MAX_ESPECIALIDAD", 0),
+	}
+}
+
+// citasActivasPaciente consulta las citas futuras pendientes del paciente, incluidas las de series
+// recurrentes
+func citasActivasPaciente(db *gorm.DB) *gorm.DB {
+	estados := append([]string{models.EstadoPorAprobar}, models.EstadosCitaPendientes...)
+	return db.Model(&models.Cita{}).
+		Where("cita.estado IN ? AND cita.fecha_cita > ?", estados, time.Now())
+}
+
+// verificarLimitesCitas comprueba, dentro de la transacción, que las nuevas citas (una, o todas
+// las de una serie) no hagan superar al paciente los límites de citas activas. Los administradores
+// pueden agendar sin límite. Si se supera algún límite responde el error con su código y devuelve false.
+func verificarLimitesCitas(c *gin.Context, tx *gorm.DB, cita models.Cita, nuevas int) bool {
+	limites := limitesCitas()
+	if c.GetString("userRol") == "administrador" || limites == (LimitesCitas{}) {
+		return true
+	}
+
+	// Bloquear al paciente serializa sus reservas concurrentes
+	var paciente models.Usuario
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&paciente, cita.PacienteID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar paciente: "+err.Error())
+		return false
+	}
+
+	var medico models.Medico
+	if err := tx.First(&medico, cita.MedicoID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar médico: "+err.Error())
+		return false
+	}
+
+	verificaciones := []struct {
+		limite  int
+		codigo  string
+		mensaje string
+		query   *gorm.DB
+	}{
+		{limites.Total, codigoLimiteCitasPaciente, "El paciente tiene %d citas activas y el máximo es %d",
+			citasActivasPaciente(tx).Where("cita.paciente_id = ?", paciente.ID)},
+		{limites.PorMedico, codigoLimiteCitasMedico, "El paciente tiene %d citas activas con este médico y el máximo es %d",
+			citasActivasPaciente(tx).Where("cita.paciente_id = ? AND cita.medico_id = ?", paciente.ID, medico.ID)},
+		{limites.PorEspecialidad, codigoLimiteCitasEspecialidad, "El paciente tiene %d citas activas de " + medico.Especialidad + " y el máximo es %d",
+			citasActivasPaciente(tx).Joins("JOIN medicos ON medicos.id = cita.medico_id").
+				Where("cita.paciente_id = ? AND medicos.especialidad = ?", paciente.ID, medico.Especialidad)},
+	}
+
+	for _, v := range verificaciones {
+		if v.limite == 0 {
+			continue
+		}
+
+		var count int64
+		if err := v.query.Count(&count).Error; err != nil {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al contar citas activas: "+err.Error())
+			return false
+		}
+
+		if count+int64(nuevas) > int64(v.limite) {
+			respuestas.RespondErrorCodigo(c, http.StatusConflict, v.codigo, fmt.Sprintf(v.mensaje, count, v.limite))
+			return false
+		}
+	}
+
+	return true
+}
+
+// GetLimitesCitas muestra los límites de citas activas y cuántas tiene el usuario actual
+func GetLimitesCitas(c *gin.Context) {
+	db := initializers.GetDB()
+	activas := CitasActivasPaciente{PorMedico: map[uint]int64{}, PorEspecialidad: map[string]int64{}}
+
+	var filas []struct {
+		MedicoID     uint
+		Especialidad string
+		Total        int64
+	}
+	if err := citasActivasPaciente(db).
+		Select("cita.medico_id, medicos.especialidad, COUNT(*) AS total").
+		Joins("JOIN medicos ON medicos.id = cita.medico_id").
+		Where("cita.paciente_id = ?", c.GetUint("userID")).
+		Group("cita.medico_id, medicos.especialidad").
+		Scan(&filas).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al contar citas activas: "+err.Error())
+		return
+	}
+
+	for _, fila := range filas {
+		activas.Total += fila.Total
+		activas.PorMedico[fila.MedicoID] += fila.Total
+		activas.PorEspecialidad[fila.Especialidad] += fila.Total
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"limites": limitesCitas(),
+		"activas": activas,
+	})
+}
@@ -0,0 +1,506 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+	"github.com/Ilimm9/CMedicas/paginacion"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ListaEsperaInput struct {
+	PacienteID   uint      `json:"paciente_id"` // Solo administradores, por defecto el usuario autenticado
+	MedicoID     *uint     `json:"medico_id"`
+	Especialidad string    `json:"especialidad" binding:"max=100"`
+	Desde        time.Time `json:"desde" binding:"required"`
+	Hasta        time.Time `json:"hasta" binding:"required"`
+}
+
+// duracionReservaListaEspera es el tiempo que se aparta un espacio ofrecido
+// (variable LISTA_ESPERA_RESERVA_MINUTOS, 120 por defecto)
+func duracionReservaListaEspera() time.Duration {
+	if minutos, err := strconv.Atoi(os.Getenv("LISTA_ESPERA_RESERVA_MINUTOS")); err == nil && minutos > 0 {
+		return time.Duration(minutos) * time.Minute
+	}
+	return 120 * time.Minute
+}
+
+// ofrecerEspacioListaEspera aparta el espacio de una cita liberada para el primer paciente de la
+// lista de espera que coincida (por médico o especialidad y rango de fechas) y le notifica la oferta.
+// Debe llamarse dentro de la transacción que liberó la cita.
+func ofrecerEspacioListaEspera(tx *gorm.DB, liberada models.Cita, excluirEntradaIDs ...uint) error {
+	if !liberada.FechaCita.After(time.Now()) {
+		return nil
+	}
+
+	var medico models.Medico
+	if err := tx.First(&medico, liberada.MedicoID).Error; err != nil {
+		return err
+	}
+
+	// No ofrecer espacios en los que el médico estará ausente o la clínica cerrada
+	if bloqueo, err := bloqueoEnRango(tx, medico.ID, liberada.FechaCita, liberada.FechaFin); err != nil || bloqueo != nil {
+		return err
+	}
+	if festivo, err := diaFestivo(tx, liberada.FechaCita); err != nil || festivo != nil {
+		return err
+	}
+
+	query := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("estado = ?", "activa").
+		Where("desde <= ? AND hasta >= ?", liberada.FechaCita, liberada.FechaFin).
+		Where("(medico_id = ? OR (medico_id IS NULL AND especialidad = ?))", medico.ID, medico.Especialidad).
+		Where("paciente_id <> ?", liberada.PacienteID)
+	if len(excluirEntradaIDs) > 0 {
+		query = query.Where("id NOT IN ?", excluirEntradaIDs)
+	}
+
+	var entrada models.ListaEspera
+	if err := query.Order("creada_en, id").First(&entrada).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		return err
+	}
+
+	reserva := models.Cita{
+		PacienteID:      entrada.PacienteID,
+		MedicoID:        liberada.MedicoID,
+		FechaCita:       liberada.FechaCita,
+		DuracionMinutos: liberada.DuracionMinutos,
+		TipoCitaID:      liberada.TipoCitaID,
+		Precio:          liberada.Precio,
+		Modalidad:       liberada.Modalidad,
+		Motivo:          "Espacio ofrecido desde la lista de espera",
+		Estado:          models.EstadoReservada,
+	}
+	if err := tx.Create(&reserva).Error; err != nil {
+		return err
+	}
+
+	// La reserva no puede durar más allá del inicio de la cita
+	expira := time.Now().Add(duracionReservaListaEspera())
+	if expira.After(liberada.FechaCita) {
+		expira = liberada.FechaCita
+	}
+
+	entrada.Estado = "ofertada"
+	entrada.CitaOfertaID = &reserva.ID
+	entrada.OfertaExpira = &expira
+	if err := tx.Save(&entrada).Error; err != nil {
+		return err
+	}
+
+	notificacion := models.Notificacion{
+		IDUsuario: entrada.PacienteID,
+		CitaID:    reserva.ID,
+		Tipo:      "oferta",
+		Mensaje: "Se liberó un espacio el " + fechaLegible(reserva.FechaCita) +
+			". Confírmelo antes del " + fechaLegible(expira) + " o se ofrecerá a otro paciente",
+		FechaEnvio: time.Now(),
+	}
+	return tx.Create(&notificacion).Error
+}
+
+// liberarOfertaListaEspera cancela la cita reservada de una entrada ofertada, deja la entrada en
+// el estado indicado y ofrece el espacio al siguiente paciente de la lista
+func liberarOfertaListaEspera(tx *gorm.DB, entrada *models.ListaEspera, nuevoEstado string) error {
+	var reserva models.Cita
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&reserva, *entrada.CitaOfertaID).Error; err != nil {
+		return err
+	}
+
+	entrada.Estado = nuevoEstado
+	entrada.CitaOfertaID = nil
+	entrada.OfertaExpira = nil
+	if err := tx.Save(entrada).Error; err != nil {
+		return err
+	}
+
+	if reserva.Estado != models.EstadoReservada {
+		return nil
+	}
+
+	if err := cambiarEstadoCita(tx, &reserva, models.EstadoCancelada, models.RolSistema, 0, "Oferta de lista de espera liberada"); err != nil {
+		return err
+	}
+
+	return ofrecerEspacioListaEspera(tx, reserva, entrada.ID)
+}
+
+// buscarEntradaListaEspera bloquea una entrada y verifica que pertenezca al usuario (o sea administrador).
+// Si algo falla responde el error y devuelve false.
+func buscarEntradaListaEspera(c *gin.Context, tx *gorm.DB, entrada *models.ListaEspera) bool {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return false
+	}
+
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(entrada, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Registro de lista de espera no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar registro: "+err.Error())
+		}
+		return false
+	}
+
+	if entrada.PacienteID != c.GetUint("userID") && c.GetString("userRol") != "administrador" {
+		respuestas.RespondError(c, http.StatusForbidden, "No tienes permiso sobre este registro de lista de espera")
+		return false
+	}
+
+	return true
+}
+
+// PostListaEspera registra a un paciente en la lista de espera de un médico o especialidad
+func PostListaEspera(c *gin.Context) {
+	var input ListaEsperaInput
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Los pacientes solo pueden registrarse a sí mismos
+	userID := c.GetUint("userID")
+	if input.PacienteID == 0 {
+		input.PacienteID = userID
+	}
+	if input.PacienteID != userID && c.GetString("userRol") != "administrador" {
+		respuestas.RespondError(c, http.StatusForbidden, "No puedes registrar a otro paciente en la lista de espera")
+		return
+	}
+
+	if input.MedicoID == nil && input.Especialidad == "" {
+		respuestas.RespondError(c, http.StatusBadRequest, "Indique el médico o la especialidad")
+		return
+	}
+
+	if !input.Hasta.After(input.Desde) || input.Hasta.Before(time.Now()) {
+		respuestas.RespondError(c, http.StatusBadRequest, "El rango de fechas debe ser válido y terminar en el futuro")
+		return
+	}
+
+	var paciente models.Usuario
+	if err := initializers.GetDB().First(&paciente, input.PacienteID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusBadRequest, "Paciente no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar paciente: "+err.Error())
+		}
+		return
+	}
+
+	entrada := models.ListaEspera{
+		PacienteID:   input.PacienteID,
+		MedicoID:     input.MedicoID,
+		Especialidad: input.Especialidad,
+		Desde:        input.Desde,
+		Hasta:        input.Hasta,
+		Estado:       "activa",
+	}
+
+	if input.MedicoID != nil {
+		var medico models.Medico
+		if err := initializers.GetDB().First(&medico, *input.MedicoID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				respuestas.RespondError(c, http.StatusBadRequest, "Médico no encontrado")
+			} else {
+				respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar médico: "+err.Error())
+			}
+			return
+		}
+		entrada.Especialidad = medico.Especialidad
+	}
+
+	if err := initializers.GetDB().Create(&entrada).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar registro: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, entrada)
+}
+
+// GetListaEspera devuelve los registros del paciente, del médico autenticado o todos para administradores
+func GetListaEspera(c *gin.Context) {
+	userID := c.GetUint("userID")
+
+	query := initializers.GetDB().Model(&models.ListaEspera{})
+
+	switch c.GetString("userRol") {
+	case "paciente":
+		query = query.Where("lista_esperas.paciente_id = ?", userID)
+	case "medico":
+		var medico models.Medico
+		if err := initializers.GetDB().Where("usuario_id = ?", userID).First(&medico).Error; err != nil {
+			respuestas.RespondError(c, http.StatusNotFound, "No se encontró médico asociado a este usuario")
+			return
+		}
+		query = query.Where("(lista_esperas.medico_id = ? OR (lista_esperas.medico_id IS NULL AND lista_esperas.especialidad = ?))", medico.ID, medico.Especialidad)
+	case "administrador":
+	default:
+		respuestas.RespondError(c, http.StatusForbidden, "Rol no autorizado para ver la lista de espera")
+		return
+	}
+
+	var entradas []models.ListaEspera
+	meta, err := paginacion.Paginar(c, query, listadoListaEspera, &entradas)
+	if err != nil {
+		responderErrorListado(c, "Error al obtener lista de espera: ", err)
+		return
+	}
+
+	respuestas.RespondSuccessPaginado(c, http.StatusOK, entradas, meta)
+}
+
+// Parámetros admitidos por la lista de espera
+var listadoListaEspera = paginacion.Listado[models.ListaEspera]{
+	Tabla: "lista_esperas",
+	ID:    func(entrada models.ListaEspera) uint { return entrada.ID },
+	Orden: map[string]paginacion.Orden[models.ListaEspera]{
+		"id":        {Columna: "lista_esperas.id", Valor: func(entrada models.ListaEspera) any { return entrada.ID }},
+		"creada_en": {Columna: "lista_esperas.creada_en", Valor: func(entrada models.ListaEspera) any { return entrada.CreadaEn }},
+	},
+	OrdenPorDefecto: "creada_en",
+	Filtros: map[string]string{
+		"estado":       "lista_esperas.estado",
+		"especialidad": "lista_esperas.especialidad",
+	},
+	FiltrosNumericos: map[string]string{
+		"medico_id":   "lista_esperas.medico_id",
+		"paciente_id": "lista_esperas.paciente_id",
+	},
+	CampoFecha: "lista_esperas.creada_en",
+	Preload:    []string{"Paciente", "Paciente.Persona", "Medico", "Medico.Usuario", "Medico.Usuario.Persona", "CitaOferta"},
+}
+
+// AceptarOfertaListaEspera confirma la cita reservada para el paciente
+func AceptarOfertaListaEspera(c *gin.Context) {
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	var entrada models.ListaEspera
+	if !buscarEntradaListaEspera(c, tx, &entrada) {
+		tx.Rollback()
+		return
+	}
+
+	if entrada.Estado != "ofertada" || entrada.CitaOfertaID == nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusBadRequest, "No hay una oferta vigente para este registro")
+		return
+	}
+
+	if entrada.OfertaExpira != nil && entrada.OfertaExpira.Before(time.Now()) {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusConflict, "La oferta ya expiró")
+		return
+	}
+
+	var cita models.Cita
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&cita, *entrada.CitaOfertaID).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar cita reservada: "+err.Error())
+		return
+	}
+
+	if cita.Estado != models.EstadoReservada {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusConflict, "El espacio ya no está disponible")
+		return
+	}
+
+	if err := cambiarEstadoCita(tx, &cita, models.EstadoProgramada, c.GetString("userRol"), c.GetUint("userID"), "Oferta de lista de espera aceptada"); err != nil {
+		tx.Rollback()
+		responderErrorTransicion(c, err)
+		return
+	}
+
+	entrada.Estado = "asignada"
+	entrada.OfertaExpira = nil
+	if err := tx.Save(&entrada).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar registro: "+err.Error())
+		return
+	}
+
+	conSala, err := conSalaVirtual(tx, cita)
+	if err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener sala virtual: "+err.Error())
+		return
+	}
+
+	notificacion := models.Notificacion{
+		IDUsuario:  cita.PacienteID,
+		CitaID:     cita.ID,
+		Tipo:       "confirmación",
+		Mensaje:    "Su cita ha sido confirmada",
+		FechaEnvio: time.Now(),
+		ConSala:    conSala,
+	}
+
+	if err := tx.Create(&notificacion).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear notificación: "+err.Error())
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	if err := initializers.GetDB().
+		Preload("Paciente").
+		Preload("Paciente.Persona").
+		Preload("Medico").
+		Preload("Medico.Usuario").
+		Preload("Medico.Usuario.Persona").
+		Preload("TipoCita").
+		First(&cita, cita.ID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos de la cita: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"message": "Cita confirmada exitosamente",
+		"cita":    cita,
+	})
+}
+
+// RechazarOfertaListaEspera libera el espacio ofrecido; el paciente sigue en la lista de espera
+func RechazarOfertaListaEspera(c *gin.Context) {
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	var entrada models.ListaEspera
+	if !buscarEntradaListaEspera(c, tx, &entrada) {
+		tx.Rollback()
+		return
+	}
+
+	if entrada.Estado != "ofertada" || entrada.CitaOfertaID == nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusBadRequest, "No hay una oferta vigente para este registro")
+		return
+	}
+
+	if err := liberarOfertaListaEspera(tx, &entrada, "activa"); err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al liberar oferta: "+err.Error())
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, entrada)
+}
+
+// CancelarListaEspera retira al paciente de la lista de espera, liberando cualquier oferta vigente
+func CancelarListaEspera(c *gin.Context) {
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	var entrada models.ListaEspera
+	if !buscarEntradaListaEspera(c, tx, &entrada) {
+		tx.Rollback()
+		return
+	}
+
+	if entrada.Estado != "activa" && entrada.Estado != "ofertada" {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusBadRequest, "El registro ya no está activo")
+		return
+	}
+
+	var err error
+	if entrada.Estado == "ofertada" && entrada.CitaOfertaID != nil {
+		err = liberarOfertaListaEspera(tx, &entrada, "cancelada")
+	} else {
+		entrada.Estado = "cancelada"
+		err = tx.Save(&entrada).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cancelar registro: "+err.Error())
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Registro de lista de espera cancelado"})
+}
+
+// expirarOfertasListaEspera libera las ofertas vencidas y ofrece los espacios al siguiente
+// paciente. Cada oferta se procesa en su propia transacción.
+func expirarOfertasListaEspera() (int, error) {
+	expiradas := 0
+	for {
+		procesada := false
+		err := initializers.GetDB().Transaction(func(tx *gorm.DB) error {
+			var entrada models.ListaEspera
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("estado = ? AND oferta_expira < ?", "ofertada", time.Now()).
+				Order("oferta_expira").
+				First(&entrada).Error
+			if err == gorm.ErrRecordNotFound {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			procesada = true
+			if entrada.CitaOfertaID == nil {
+				entrada.Estado = "expirada"
+				return tx.Save(&entrada).Error
+			}
+			return liberarOfertaListaEspera(tx, &entrada, "expirada")
+		})
+		if err != nil || !procesada {
+			return expiradas, err
+		}
+		expiradas++
+	}
+}
+
+// ProcesarListaEspera revisa periódicamente las ofertas vencidas. Se ejecuta en segundo plano.
+func ProcesarListaEspera(intervalo time.Duration) {
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if n, err := expirarOfertasListaEspera(); err != nil {
+			log.Println("Error al expirar ofertas de lista de espera:", err)
+		} else if n > 0 {
+			log.Printf("Ofertas de lista de espera expiradas: %d", n)
+		}
+	}
+}
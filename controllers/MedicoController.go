@@ -8,14 +8,16 @@ import (
 	"github.com/Ilimm9/CMedicas/Respuestas"
 	"github.com/Ilimm9/CMedicas/initializers"
 	"github.com/Ilimm9/CMedicas/models"
+	"github.com/Ilimm9/CMedicas/paginacion"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type MedicoInput struct {
-	UsuarioID    uint   `json:"usuario_id" binding:"required"`
-	Especialidad string `json:"especialidad" binding:"required,max=100"`
+	UsuarioID           uint   `json:"usuario_id" binding:"required"`
+	Especialidad        string `json:"especialidad" binding:"required,max=100"`
+	DuracionCitaMinutos *int   `json:"duracion_cita_minutos" binding:"omitempty,min=5,max=480"`
 }
 
 // PostMedico crea un nuevo médico
@@ -50,8 +52,9 @@ func PostMedico(c *gin.Context) {
 	}
 
 	medico := models.Medico{
-		UsuarioID:    input.UsuarioID,
-		Especialidad: input.Especialidad,
+		UsuarioID:           input.UsuarioID,
+		Especialidad:        input.Especialidad,
+		DuracionCitaMinutos: input.DuracionCitaMinutos,
 	}
 
 	if err := tx.Create(&medico).Error; err != nil {
@@ -74,6 +77,30 @@ func PostMedico(c *gin.Context) {
 	respuestas.RespondSuccess(c, http.StatusCreated, medico)
 }
 
+// medicoDeUsuario obtiene el médico asociado a un usuario
+func medicoDeUsuario(db *gorm.DB, usuarioID uint) (models.Medico, error) {
+	var medico models.Medico
+	err := db.Where("usuario_id = ?", usuarioID).First(&medico).Error
+	return medico, err
+}
+
+// verificarAccesoMedico permite operar la agenda y la sala de espera de un médico al personal de
+// la clínica y al propio médico. Si no tiene acceso responde el error y devuelve false.
+func verificarAccesoMedico(c *gin.Context, db *gorm.DB, medicoID uint) bool {
+	switch c.GetString("userRol") {
+	case "administrador", "recepcionista":
+		return true
+	case "medico":
+		medico, err := medicoDeUsuario(db, c.GetUint("userID"))
+		if err == nil && medico.ID == medicoID {
+			return true
+		}
+	}
+
+	respuestas.RespondError(c, http.StatusForbidden, "No tienes permiso sobre la agenda de este médico")
+	return false
+}
+
 // GetMedico obtiene un médico por ID
 func GetMedico(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
@@ -104,17 +131,32 @@ func GetMedico(c *gin.Context) {
 // GetAllMedicos obtiene todos los médicos
 func GetAllMedicos(c *gin.Context) {
 	var medicos []models.Medico
-	result := initializers.GetDB().
-		Preload("Usuario").
-		Preload("Usuario.Persona").
-		Find(&medicos)
-
-	if result.Error != nil {
-		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener médicos: "+result.Error.Error())
+	meta, err := paginacion.Paginar(c, initializers.GetDB(), listadoMedicos, &medicos)
+	if err != nil {
+		responderErrorListado(c, "Error al obtener médicos: ", err)
 		return
 	}
 
-	respuestas.RespondSuccess(c, http.StatusOK, medicos)
+	respuestas.RespondSuccessPaginado(c, http.StatusOK, medicos, meta)
+}
+
+// Parámetros admitidos por el listado de médicos
+var listadoMedicos = paginacion.Listado[models.Medico]{
+	Tabla: "medicos",
+	ID:    func(medico models.Medico) uint { return medico.ID },
+	Orden: map[string]paginacion.Orden[models.Medico]{
+		"id":           {Columna: "medicos.id", Valor: func(medico models.Medico) any { return medico.ID }},
+		"especialidad": {Columna: "medicos.especialidad", Valor: func(medico models.Medico) any { return medico.Especialidad }},
+	},
+	OrdenPorDefecto: "id",
+	Filtros: map[string]string{
+		"especialidad": "medicos.especialidad",
+	},
+	FiltrosNumericos: map[string]string{
+		"usuario_id": "medicos.usuario_id",
+	},
+	Busqueda: []string{"medicos.especialidad"},
+	Preload:  []string{"Usuario", "Usuario.Persona"},
 }
 
 // UpdateMedico actualiza un médico existente
@@ -126,7 +168,8 @@ func UpdateMedico(c *gin.Context) {
 	}
 
 	var input struct {
-		Especialidad string `json:"especialidad" binding:"max=100"`
+		Especialidad        string `json:"especialidad" binding:"max=100"`
+		DuracionCitaMinutos *int   `json:"duracion_cita_minutos" binding:"omitempty,min=0,max=480"` // 0 vuelve a usar la de la especialidad
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -155,6 +198,13 @@ func UpdateMedico(c *gin.Context) {
 	if input.Especialidad != "" {
 		medico.Especialidad = input.Especialidad
 	}
+	if input.DuracionCitaMinutos != nil {
+		if *input.DuracionCitaMinutos == 0 {
+			medico.DuracionCitaMinutos = nil
+		} else {
+			medico.DuracionCitaMinutos = input.DuracionCitaMinutos
+		}
+	}
 
 	if err := tx.Save(&medico).Error; err != nil {
 		tx.Rollback()
@@ -3,11 +3,13 @@ package controllers
 import (
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Ilimm9/CMedicas/Respuestas"
 	"github.com/Ilimm9/CMedicas/initializers"
 	"github.com/Ilimm9/CMedicas/models"
+	"github.com/Ilimm9/CMedicas/paginacion"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -16,7 +18,7 @@ import (
 type NotificacionInput struct {
 	IDUsuario uint   `json:"usuario_id" binding:"required"`
 	CitaID    uint   `json:"cita_id" binding:"required"`
-	Tipo      string `json:"tipo" binding:"required,oneof=confirmación recordatorio cancelación"`
+	Tipo      string `json:"tipo" binding:"required,oneof=confirmación recordatorio cancelación oferta reprogramación aviso"`
 	Mensaje   string `json:"mensaje" binding:"required,max=500"`
 }
 
@@ -177,7 +179,7 @@ func UpdateNotificacion(c *gin.Context) {
 	}
 
 	var input struct {
-		Tipo    string `json:"tipo" binding:"omitempty,oneof=confirmación recordatorio cancelación"`
+		Tipo    string `json:"tipo" binding:"omitempty,oneof=confirmación recordatorio cancelación oferta reprogramación aviso"`
 		Mensaje string `json:"mensaje" binding:"omitempty,max=500"`
 	}
 
@@ -269,3 +271,174 @@ func DeleteNotificacion(c *gin.Context) {
 
 	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Notificación eliminada correctamente"})
 }
+
+// MetaBandeja agrega a la paginación el número de notificaciones sin leer del usuario
+type MetaBandeja struct {
+	*paginacion.Meta
+	NoLeidas int64 `json:"no_leidas"`
+}
+
+// bandejaUsuario filtra las notificaciones del usuario actual
+func bandejaUsuario(c *gin.Context) *gorm.DB {
+	return initializers.GetDB().Model(&models.Notificacion{}).Where("notificacions.id_usuario = ?", c.GetUint("userID"))
+}
+
+// contarNoLeidas cuenta las notificaciones sin leer ni archivar del usuario actual
+func contarNoLeidas(c *gin.Context) (int64, error) {
+	var noLeidas int64
+	err := bandejaUsuario(c).Where("leida_en IS NULL AND archivada_en IS NULL").Count(&noLeidas).Error
+	return noLeidas, err
+}
+
+// GetNotificacionesUsuarioActual devuelve la bandeja del usuario actual, de la más reciente a la
+// más antigua, con el total sin leer. Parámetros: tipo (lista separada por comas), cita_id,
+// leida (true/false), archivadas=true para ver solo las archivadas, más los de los listados.
+func GetNotificacionesUsuarioActual(c *gin.Context) {
+	query := bandejaUsuario(c)
+
+	if c.Query("archivadas") == "true" {
+		query = query.Where("notificacions.archivada_en IS NOT NULL")
+	} else {
+		query = query.Where("notificacions.archivada_en IS NULL")
+	}
+
+	switch c.Query("leida") {
+	case "":
+	case "true":
+		query = query.Where("notificacions.leida_en IS NOT NULL")
+	case "false":
+		query = query.Where("notificacions.leida_en IS NULL")
+	default:
+		respuestas.RespondError(c, http.StatusBadRequest, "El parámetro leida debe ser true o false")
+		return
+	}
+
+	var notificaciones []models.Notificacion
+	meta, err := paginacion.Paginar(c, query, listadoBandeja, &notificaciones)
+	if err != nil {
+		responderErrorListado(c, "Error al obtener notificaciones: ", err)
+		return
+	}
+
+	noLeidas, err := contarNoLeidas(c)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al contar notificaciones: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccessPaginado(c, http.StatusOK, notificaciones, MetaBandeja{Meta: meta, NoLeidas: noLeidas})
+}
+
+// Parámetros admitidos por la bandeja de notificaciones
+var listadoBandeja = paginacion.Listado[models.Notificacion]{
+	Tabla: "notificacions",
+	ID:    func(notificacion models.Notificacion) uint { return notificacion.ID },
+	Orden: map[string]paginacion.Orden[models.Notificacion]{
+		"id":          {Columna: "notificacions.id", Valor: func(notificacion models.Notificacion) any { return notificacion.ID }},
+		"fecha_envio": {Columna: "notificacions.fecha_envio", Valor: func(notificacion models.Notificacion) any { return notificacion.FechaEnvio }},
+	},
+	OrdenPorDefecto: "-fecha_envio",
+	Filtros: map[string]string{
+		"tipo": "notificacions.tipo",
+	},
+	FiltrosNumericos: map[string]string{
+		"cita_id": "notificacions.cita_id",
+	},
+	CampoFecha: "notificacions.fecha_envio",
+	Busqueda:   []string{"notificacions.mensaje"},
+}
+
+// GetNotificacionesNoLeidas devuelve solo el número de notificaciones sin leer del usuario actual
+func GetNotificacionesNoLeidas(c *gin.Context) {
+	noLeidas, err := contarNoLeidas(c)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al contar notificaciones: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"no_leidas": noLeidas})
+}
+
+// actualizarNotificacionUsuario aplica los cambios a una notificación del usuario actual y la
+// devuelve; las de otros usuarios se tratan como inexistentes
+func actualizarNotificacionUsuario(c *gin.Context, cambios map[string]interface{}) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var notificacion models.Notificacion
+	if err := bandejaUsuario(c).First(&notificacion, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Notificación no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar notificación: "+err.Error())
+		}
+		return
+	}
+
+	if err := initializers.GetDB().Model(&notificacion).Updates(cambios).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar notificación: "+err.Error())
+		return
+	}
+
+	// Recargar para devolver las fechas calculadas en la base de datos
+	if err := initializers.GetDB().First(&notificacion, notificacion.ID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos actualizados: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, notificacion)
+}
+
+// MarcarNotificacionLeida marca una notificación del usuario actual como leída. Si ya lo estaba
+// conserva la fecha de la primera lectura.
+func MarcarNotificacionLeida(c *gin.Context) {
+	actualizarNotificacionUsuario(c, map[string]interface{}{
+		"leida_en": gorm.Expr("COALESCE(leida_en, ?)", time.Now()),
+	})
+}
+
+// MarcarNotificacionNoLeida vuelve a dejar una notificación del usuario actual sin leer
+func MarcarNotificacionNoLeida(c *gin.Context) {
+	actualizarNotificacionUsuario(c, map[string]interface{}{"leida_en": nil})
+}
+
+// ArchivarNotificacion saca una notificación de la bandeja del usuario actual; archivarla también
+// la marca como leída
+func ArchivarNotificacion(c *gin.Context) {
+	ahora := time.Now()
+	actualizarNotificacionUsuario(c, map[string]interface{}{
+		"leida_en":     gorm.Expr("COALESCE(leida_en, ?)", ahora),
+		"archivada_en": ahora,
+	})
+}
+
+// DesarchivarNotificacion devuelve una notificación archivada a la bandeja
+func DesarchivarNotificacion(c *gin.Context) {
+	actualizarNotificacionUsuario(c, map[string]interface{}{"archivada_en": nil})
+}
+
+// MarcarNotificacionesLeidas marca como leídas todas las notificaciones sin leer del usuario
+// actual, o solo las de los tipos indicados (?tipo=recordatorio,aviso)
+func MarcarNotificacionesLeidas(c *gin.Context) {
+	query := bandejaUsuario(c).Where("leida_en IS NULL")
+	if tipo := c.Query("tipo"); tipo != "" {
+		query = query.Where("tipo IN ?", strings.Split(tipo, ","))
+	}
+
+	resultado := query.Update("leida_en", time.Now())
+	if resultado.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al marcar notificaciones: "+resultado.Error.Error())
+		return
+	}
+
+	noLeidas, err := contarNoLeidas(c)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al contar notificaciones: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"marcadas": resultado.RowsAffected, "no_leidas": noLeidas})
+}
@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+	"github.com/Ilimm9/CMedicas/paginacion"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Espera máxima entre dos intentos de un evento
+const esperaMaximaOutbox = 6 * time.Hour
+
+// manejadorOutbox procesa un tipo de evento. Si procesar devuelve error sus cambios se deshacen y
+// el evento se reintenta; fallo, si existe, se llama después con el evento ya actualizado.
+type manejadorOutbox struct {
+	procesar func(tx *gorm.DB, evento models.EventoOutbox) error
+	fallo    func(tx *gorm.DB, evento models.EventoOutbox) error
+}
+
+// Manejadores por tipo de evento. Los eventos sin manejador (cambios de estado y reprogramaciones
+// de citas) se marcan como procesados: quedan como historial para consumidores externos.
+var manejadoresOutbox = map[string]manejadorOutbox{
+	models.EventoNotificacionCreada: {procesar: despacharNotificacion},
+	models.EventoEntregaEnviar:      {procesar: enviarEntrega, fallo: falloEntrega},
+}
+
+// maxIntentosOutbox es el número de intentos antes de mover un evento a fallidos
+// (variable OUTBOX_MAX_INTENTOS, 8 por defecto)
+func maxIntentosOutbox() int {
+	return enteroEnv("OUTBOX_MAX_INTENTOS", 8)
+}
+
+// esperaOutbox calcula la espera antes del siguiente intento: OUTBOX_ESPERA_SEGUNDOS (30 por
+// defecto) tras el primer fallo, duplicándose en cada uno de los siguientes
+func esperaOutbox(intentos int) time.Duration {
+	espera := time.Duration(enteroEnv("OUTBOX_ESPERA_SEGUNDOS", 30)) * time.Second
+	for i := 1; i < intentos && espera < esperaMaximaOutbox; i++ {
+		espera *= 2
+	}
+	if espera > esperaMaximaOutbox {
+		espera = esperaMaximaOutbox
+	}
+	return espera
+}
+
+// procesarOutbox atiende los eventos pendientes que ya tocan. Cada evento se procesa en su propia
+// transacción y se bloquea con SKIP LOCKED, de modo que varias réplicas pueden ejecutarlo a la vez.
+// Un evento puede atenderse más de una vez si el proceso cae tras un envío externo y antes de
+// confirmar; por eso los mensajes llevan una clave para descartar duplicados.
+func procesarOutbox() (int, int, error) {
+	procesados, fallidos := 0, 0
+	for {
+		atendido := false
+		err := initializers.GetDB().Transaction(func(tx *gorm.DB) error {
+			var evento models.EventoOutbox
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("estado = ? AND proximo_intento <= ?", models.OutboxPendiente, time.Now()).
+				Order("proximo_intento, id").
+				First(&evento).Error
+			if err == gorm.ErrRecordNotFound {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			atendido = true
+
+			evento.Intentos++
+			manejador, ok := manejadoresOutbox[evento.Tipo]
+
+			// La transacción anidada usa un savepoint: si el manejador falla, solo se deshacen sus cambios
+			if ok {
+				err = tx.Transaction(func(tx *gorm.DB) error {
+					return manejador.procesar(tx, evento)
+				})
+			}
+
+			ahora := time.Now()
+			if err == nil {
+				evento.Estado = models.OutboxProcesado
+				evento.ProcesadoEn = &ahora
+				evento.UltimoError = ""
+				procesados++
+				return tx.Save(&evento).Error
+			}
+
+			evento.UltimoError = err.Error()
+			if evento.Intentos >= maxIntentosOutbox() {
+				evento.Estado = models.OutboxFallido
+				fallidos++
+			} else {
+				evento.ProximoIntento = ahora.Add(esperaOutbox(evento.Intentos))
+			}
+
+			if err := tx.Save(&evento).Error; err != nil {
+				return err
+			}
+			if manejador.fallo != nil {
+				return manejador.fallo(tx, evento)
+			}
+			return nil
+		})
+		if err != nil || !atendido {
+			return procesados, fallidos, err
+		}
+	}
+}
+
+// ProcesarOutbox atiende periódicamente los eventos del outbox. Se ejecuta en segundo plano.
+func ProcesarOutbox(intervalo time.Duration) {
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if procesados, fallidos, err := procesarOutbox(); err != nil {
+			log.Println("Error al procesar el outbox:", err)
+		} else if procesados > 0 || fallidos > 0 {
+			log.Printf("Eventos del outbox procesados: %d, movidos a fallidos: %d", procesados, fallidos)
+		}
+	}
+}
+
+// GetAllEventosOutbox lista los eventos del outbox, p. ej. ?estado=fallido&tipo=entrega.enviar
+func GetAllEventosOutbox(c *gin.Context) {
+	var eventos []models.EventoOutbox
+	meta, err := paginacion.Paginar(c, initializers.GetDB(), listadoEventosOutbox, &eventos)
+	if err != nil {
+		responderErrorListado(c, "Error al obtener eventos: ", err)
+		return
+	}
+
+	respuestas.RespondSuccessPaginado(c, http.StatusOK, eventos, meta)
+}
+
+// Parámetros admitidos por el listado de eventos del outbox
+var listadoEventosOutbox = paginacion.Listado[models.EventoOutbox]{
+	Tabla: "evento_outboxes",
+	ID:    func(evento models.EventoOutbox) uint { return evento.ID },
+	Orden: map[string]paginacion.Orden[models.EventoOutbox]{
+		"id":              {Columna: "evento_outboxes.id", Valor: func(evento models.EventoOutbox) any { return evento.ID }},
+		"creado_en":       {Columna: "evento_outboxes.creado_en", Valor: func(evento models.EventoOutbox) any { return evento.CreadoEn }},
+		"proximo_intento": {Columna: "evento_outboxes.proximo_intento", Valor: func(evento models.EventoOutbox) any { return evento.ProximoIntento }},
+	},
+	OrdenPorDefecto: "-id",
+	Filtros: map[string]string{
+		"estado": "evento_outboxes.estado",
+		"tipo":   "evento_outboxes.tipo",
+	},
+	FiltrosNumericos: map[string]string{
+		"referencia": "evento_outboxes.referencia",
+	},
+	CampoFecha: "evento_outboxes.creado_en",
+	Busqueda:   []string{"evento_outboxes.ultimo_error"},
+}
+
+// GetEventoOutbox devuelve un evento con sus datos y el último error
+func GetEventoOutbox(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var evento models.EventoOutbox
+	if err := initializers.GetDB().First(&evento, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Evento no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar evento: "+err.Error())
+		}
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, evento)
+}
+
+// reintentoOutbox devuelve un evento a pendiente con sus intentos desde cero para que el worker lo
+// atienda en la siguiente vuelta
+func reintentoOutbox() map[string]interface{} {
+	return map[string]interface{}{
+		"estado":          models.OutboxPendiente,
+		"intentos":        0,
+		"proximo_intento": time.Now(),
+	}
+}
+
+// ReintentarEventoOutbox vuelve a encolar un evento fallido, o adelanta uno pendiente
+func ReintentarEventoOutbox(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	db := initializers.GetDB()
+
+	var evento models.EventoOutbox
+	if err := db.First(&evento, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Evento no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar evento: "+err.Error())
+		}
+		return
+	}
+
+	if evento.Estado == models.OutboxProcesado {
+		respuestas.RespondError(c, http.StatusConflict, "El evento ya fue procesado")
+		return
+	}
+
+	// La condición sobre el estado evita pisar un evento que el worker acaba de procesar
+	resultado := db.Model(&evento).Where("estado <> ?", models.OutboxProcesado).Updates(reintentoOutbox())
+	if resultado.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al reintentar evento: "+resultado.Error.Error())
+		return
+	}
+	if resultado.RowsAffected == 0 {
+		respuestas.RespondError(c, http.StatusConflict, "El evento ya fue procesado")
+		return
+	}
+
+	db.First(&evento, id)
+	respuestas.RespondSuccess(c, http.StatusOK, evento)
+}
+
+// ReintentarEventosFallidos vuelve a encolar todos los eventos fallidos, opcionalmente solo los
+// de un tipo (?tipo=entrega.enviar)
+func ReintentarEventosFallidos(c *gin.Context) {
+	query := initializers.GetDB().Model(&models.EventoOutbox{}).Where("estado = ?", models.OutboxFallido)
+	if tipo := c.Query("tipo"); tipo != "" {
+		query = query.Where("tipo = ?", tipo)
+	}
+
+	resultado := query.Updates(reintentoOutbox())
+	if resultado.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al reintentar eventos: "+resultado.Error.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"reintentados": resultado.RowsAffected})
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/Ilimm9/CMedicas/Respuestas"
 	"github.com/Ilimm9/CMedicas/initializers"
 	"github.com/Ilimm9/CMedicas/models"
+	"github.com/Ilimm9/CMedicas/paginacion"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -87,13 +88,30 @@ func GetPersona(c *gin.Context) {
 // Obtener todas las personas
 func GetAllPersonas(c *gin.Context) {
 	var personas []models.Persona
-	result := initializers.GetDB().Find(&personas)
-	if result.Error != nil {
-		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener personas: "+result.Error.Error())
+	meta, err := paginacion.Paginar(c, initializers.GetDB(), listadoPersonas, &personas)
+	if err != nil {
+		responderErrorListado(c, "Error al obtener personas: ", err)
 		return
 	}
 
-	respuestas.RespondSuccess(c, http.StatusOK, personas)
+	respuestas.RespondSuccessPaginado(c, http.StatusOK, personas, meta)
+}
+
+// Parámetros admitidos por el listado de personas
+var listadoPersonas = paginacion.Listado[models.Persona]{
+	Tabla: "personas",
+	ID:    func(persona models.Persona) uint { return persona.ID },
+	Orden: map[string]paginacion.Orden[models.Persona]{
+		"id":               {Columna: "personas.id", Valor: func(persona models.Persona) any { return persona.ID }},
+		"nombre":           {Columna: "personas.nombre", Valor: func(persona models.Persona) any { return persona.Nombre }},
+		"apellido_paterno": {Columna: "personas.apellido_paterno", Valor: func(persona models.Persona) any { return persona.ApellidoPaterno }},
+	},
+	OrdenPorDefecto: "id",
+	Filtros: map[string]string{
+		"genero": "personas.genero",
+	},
+	CampoFecha: "personas.fecha_nacimiento",
+	Busqueda:   []string{"personas.nombre", "personas.apellido_paterno", "personas.apellido_materno", "personas.telefono"},
 }
 
 // Actualizar una persona existente
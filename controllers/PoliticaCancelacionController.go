@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Los campos omitidos toman los valores por defecto de la política
+type PoliticaCancelacionInput struct {
+	Nivel             string `json:"nivel" binding:"required,oneof=clinica especialidad medico"`
+	Especialidad      string `json:"especialidad" binding:"max=100"`
+	MedicoID          *uint  `json:"medico_id"`
+	AnticipacionHoras *int   `json:"anticipacion_horas" binding:"omitempty,min=0,max=720"`
+	PermitePaciente   *bool  `json:"permite_paciente"`
+	PermiteMedico     *bool  `json:"permite_medico"`
+	PermiteTardia     *bool  `json:"permite_tardia"`
+}
+
+// aplicar copia la configuración recibida en la política
+func (input PoliticaCancelacionInput) aplicar(politica *models.PoliticaCancelacion) {
+	porDefecto := models.PoliticaCancelacionPorDefecto()
+
+	politica.Nivel = input.Nivel
+	politica.Especialidad = input.Especialidad
+	politica.MedicoID = input.MedicoID
+	politica.AnticipacionHoras = porDefecto.AnticipacionHoras
+	if input.AnticipacionHoras != nil {
+		politica.AnticipacionHoras = *input.AnticipacionHoras
+	}
+	politica.PermitePaciente = porDefecto.PermitePaciente
+	if input.PermitePaciente != nil {
+		politica.PermitePaciente = *input.PermitePaciente
+	}
+	politica.PermiteMedico = porDefecto.PermiteMedico
+	if input.PermiteMedico != nil {
+		politica.PermiteMedico = *input.PermiteMedico
+	}
+	politica.PermiteTardia = porDefecto.PermiteTardia
+	if input.PermiteTardia != nil {
+		politica.PermiteTardia = *input.PermiteTardia
+	}
+}
+
+// politicaCancelacion resuelve la política que aplica a las citas de un médico:
+// primero la del médico, luego la de su especialidad, luego la de la clínica y por último la por defecto
+func politicaCancelacion(db *gorm.DB, medico models.Medico) (models.PoliticaCancelacion, error) {
+	var politicas []models.PoliticaCancelacion
+	err := db.Where("(nivel = ? AND medico_id = ?) OR (nivel = ? AND especialidad = ?) OR nivel = ?",
+		models.PoliticaMedico, medico.ID, models.PoliticaEspecialidad, medico.Especialidad, models.PoliticaClinica).
+		Find(&politicas).Error
+	if err != nil {
+		return models.PoliticaCancelacion{}, err
+	}
+
+	for _, nivel := range []string{models.PoliticaMedico, models.PoliticaEspecialidad, models.PoliticaClinica} {
+		for _, politica := range politicas {
+			if politica.Nivel == nivel {
+				return politica, nil
+			}
+		}
+	}
+
+	return models.PoliticaCancelacionPorDefecto(), nil
+}
+
+// buscarPoliticaDuplicada verifica que no exista otra política para el mismo nivel y destino
+func buscarPoliticaDuplicada(db *gorm.DB, politica models.PoliticaCancelacion) (bool, error) {
+	query := db.Model(&models.PoliticaCancelacion{}).Where("nivel = ? AND id <> ?", politica.Nivel, politica.ID)
+	switch politica.Nivel {
+	case models.PoliticaEspecialidad:
+		query = query.Where("especialidad = ?", politica.Especialidad)
+	case models.PoliticaMedico:
+		query = query.Where("medico_id = ?", politica.MedicoID)
+	}
+
+	var count int64
+	err := query.Count(&count).Error
+	return count > 0, err
+}
+
+// validarDestinoPolitica comprueba que el nivel venga con su especialidad o médico y deja vacíos
+// los campos que no corresponden. Si algo falla responde el error y devuelve false.
+func validarDestinoPolitica(c *gin.Context, politica *models.PoliticaCancelacion) bool {
+	switch politica.Nivel {
+	case models.PoliticaClinica:
+		politica.Especialidad = ""
+		politica.MedicoID = nil
+	case models.PoliticaEspecialidad:
+		if politica.Especialidad == "" {
+			respuestas.RespondError(c, http.StatusBadRequest, "Indique la especialidad de la política")
+			return false
+		}
+		politica.MedicoID = nil
+	case models.PoliticaMedico:
+		if politica.MedicoID == nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Indique el médico de la política")
+			return false
+		}
+		var medico models.Medico
+		if err := initializers.GetDB().First(&medico, *politica.MedicoID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				respuestas.RespondError(c, http.StatusBadRequest, "Médico no encontrado")
+			} else {
+				respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar médico: "+err.Error())
+			}
+			return false
+		}
+		politica.Especialidad = ""
+	}
+
+	duplicada, err := buscarPoliticaDuplicada(initializers.GetDB(), *politica)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar política: "+err.Error())
+		return false
+	}
+	if duplicada {
+		respuestas.RespondError(c, http.StatusConflict, "Ya existe una política para ese nivel")
+		return false
+	}
+
+	return true
+}
+
+// PostPoliticaCancelacion crea una política de cancelación
+func PostPoliticaCancelacion(c *gin.Context) {
+	var input PoliticaCancelacionInput
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var politica models.PoliticaCancelacion
+	input.aplicar(&politica)
+
+	if !validarDestinoPolitica(c, &politica) {
+		return
+	}
+
+	// Select("*") para guardar también los false explícitos en lugar de los valores por defecto
+	if err := initializers.GetDB().Select("*").Omit("ID").Create(&politica).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar política: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, politica)
+}
+
+// GetAllPoliticasCancelacion obtiene todas las políticas configuradas
+func GetAllPoliticasCancelacion(c *gin.Context) {
+	var politicas []models.PoliticaCancelacion
+	if err := initializers.GetDB().Order("nivel, especialidad, medico_id").Find(&politicas).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener políticas: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, politicas)
+}
+
+// GetPoliticaCancelacionMedico obtiene la política que aplica a las citas de un médico
+func GetPoliticaCancelacionMedico(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var medico models.Medico
+	if err := initializers.GetDB().First(&medico, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Médico no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar médico: "+err.Error())
+		}
+		return
+	}
+
+	politica, err := politicaCancelacion(initializers.GetDB(), medico)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener política: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, politica)
+}
+
+// UpdatePoliticaCancelacion reemplaza la configuración de una política
+func UpdatePoliticaCancelacion(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var input PoliticaCancelacionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var politica models.PoliticaCancelacion
+	if err := initializers.GetDB().First(&politica, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Política no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar política: "+err.Error())
+		}
+		return
+	}
+
+	input.aplicar(&politica)
+
+	if !validarDestinoPolitica(c, &politica) {
+		return
+	}
+
+	if err := initializers.GetDB().Save(&politica).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar política: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, politica)
+}
+
+// DeletePoliticaCancelacion elimina una política; las citas pasan a regirse por el nivel superior
+func DeletePoliticaCancelacion(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	result := initializers.GetDB().Delete(&models.PoliticaCancelacion{}, id)
+	if result.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al eliminar política: "+result.Error.Error())
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		respuestas.RespondError(c, http.StatusNotFound, "Política no encontrada")
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Política eliminada correctamente"})
+}
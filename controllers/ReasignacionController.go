@@ -0,0 +1,418 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Acciones y destinos de la reasignación masiva
+const (
+	accionReasignacionCancelar    = "cancelar"
+	accionReasignacionReprogramar = "reprogramar"
+
+	destinoMismoMedico  = "mismo_medico"
+	destinoEspecialidad = "especialidad"
+)
+
+// Resultado de cada cita en el reporte de la reasignación
+const (
+	resultadoCancelada    = "cancelada"
+	resultadoReprogramada = "reprogramada"
+	resultadoSinEspacio   = "sin_espacio"
+)
+
+// Estados de las citas que se mueven o cancelan; las que ya están en sala o en consulta no se tocan
+var estadosReasignables = []string{models.EstadoReservada, models.EstadoPorAprobar, models.EstadoProgramada, models.EstadoConfirmada}
+
+type ReasignacionInput struct {
+	Inicio             time.Time  `json:"inicio" binding:"required"`
+	Fin                *time.Time `json:"fin"`          // Opcional en día completo: último día afectado
+	DiaCompleto        bool       `json:"dia_completo"` // Cubre los días completos de inicio a fin
+	Motivo             string     `json:"motivo" binding:"required,max=500"`
+	Accion             string     `json:"accion" binding:"required,oneof=cancelar reprogramar"`
+	Destino            string     `json:"destino" binding:"omitempty,oneof=mismo_medico especialidad"` // Por defecto el mismo médico
+	DiasBusqueda       int        `json:"dias_busqueda" binding:"omitempty,min=1,max=62"`              // Días alrededor del rango en que se buscan espacios (14)
+	CancelarSinEspacio bool       `json:"cancelar_sin_espacio"`                                        // Cancelar las citas para las que no haya espacio
+	BloquearAgenda     *bool      `json:"bloquear_agenda"`                                             // Registrar la ausencia del médico (sí por defecto)
+	Simular            bool       `json:"simular"`                                                     // Solo proponer, sin guardar cambios
+}
+
+// ResultadoReasignacion es una línea del reporte: qué pasó con cada cita afectada
+type ResultadoReasignacion struct {
+	CitaID        uint       `json:"cita_id"`
+	PacienteID    uint       `json:"paciente_id"`
+	FechaAnterior time.Time  `json:"fecha_anterior"`
+	Resultado     string     `json:"resultado"`
+	FechaNueva    *time.Time `json:"fecha_nueva,omitempty"`
+	MedicoID      uint       `json:"medico_id"` // Médico que atenderá la cita
+}
+
+// buscadorEspacios encuentra el espacio libre más cercano a una cita entre varios médicos. Guarda
+// la disponibilidad de cada médico y descarta los espacios que se van asignando.
+type buscadorEspacios struct {
+	tx           *gorm.DB
+	medicos      []models.Medico
+	excluirID    uint // Médico ausente
+	ausencia     Slot
+	desde, hasta time.Time
+	slots        map[uint]map[time.Duration][]Slot
+}
+
+// candidatos devuelve los espacios de todos los médicos para la duración, del más cercano al más lejano
+func (b *buscadorEspacios) candidatos(fecha time.Time, duracion time.Duration) ([]Slot, []uint, error) {
+	var slots []Slot
+	var medicos []uint
+	for _, medico := range b.medicos {
+		if b.slots[medico.ID] == nil {
+			b.slots[medico.ID] = map[time.Duration][]Slot{}
+		}
+		disponibles, ok := b.slots[medico.ID][duracion]
+		if !ok {
+			var err error
+			disponibles, err = calcularDisponibilidad(b.tx, medico.ID, b.desde, b.hasta, duracion)
+			if err != nil {
+				return nil, nil, err
+			}
+			b.slots[medico.ID][duracion] = disponibles
+		}
+
+		for _, slot := range disponibles {
+			// Aunque no se registre la ausencia, no se reprograma dentro de ella
+			if medico.ID == b.excluirID && slot.Inicio.Before(b.ausencia.Fin) && slot.Fin.After(b.ausencia.Inicio) {
+				continue
+			}
+			slots = append(slots, slot)
+			medicos = append(medicos, medico.ID)
+		}
+	}
+
+	distancia := func(t time.Time) time.Duration {
+		if d := t.Sub(fecha); d >= 0 {
+			return d
+		}
+		return fecha.Sub(t)
+	}
+	indices := make([]int, len(slots))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(i, j int) bool {
+		return distancia(slots[indices[i]].Inicio) < distancia(slots[indices[j]].Inicio)
+	})
+
+	ordenados := make([]Slot, len(slots))
+	medicosOrdenados := make([]uint, len(slots))
+	for i, indice := range indices {
+		ordenados[i] = slots[indice]
+		medicosOrdenados[i] = medicos[indice]
+	}
+	return ordenados, medicosOrdenados, nil
+}
+
+// ocupar descarta de la disponibilidad del médico los espacios que se cruzan con el asignado
+func (b *buscadorEspacios) ocupar(medicoID uint, ocupado Slot) {
+	for duracion, disponibles := range b.slots[medicoID] {
+		libres := disponibles[:0:0]
+		for _, slot := range disponibles {
+			if !(slot.Inicio.Before(ocupado.Fin) && slot.Fin.After(ocupado.Inicio)) {
+				libres = append(libres, slot)
+			}
+		}
+		b.slots[medicoID][duracion] = libres
+	}
+}
+
+// ReasignarCitasMedico cancela o reprograma todas las citas de un médico en un rango, por ejemplo
+// cuando se reporta enfermo. Al reprogramar busca el espacio libre más cercano con el mismo médico
+// o con otro de su especialidad. Notifica a cada paciente y devuelve un reporte por cita.
+// Todo ocurre en una transacción; con simular se devuelve la propuesta sin guardar. Volver a
+// ejecutarla es seguro: las citas ya procesadas salen del rango o dejan de estar pendientes.
+func ReasignarCitasMedico(c *gin.Context) {
+	medicoID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID de médico inválido")
+		return
+	}
+
+	var input ReasignacionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	inicio, fin, ok := rangoBloqueo(BloqueoAgendaInput{Inicio: input.Inicio, Fin: input.Fin, DiaCompleto: input.DiaCompleto})
+	if !ok {
+		respuestas.RespondError(c, http.StatusBadRequest, "Indique un fin posterior al inicio, o use dia_completo")
+		return
+	}
+
+	if input.Destino == "" {
+		input.Destino = destinoMismoMedico
+	}
+	if input.DiasBusqueda == 0 {
+		input.DiasBusqueda = 14
+	}
+
+	userID := c.GetUint("userID")
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	var medico models.Medico
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&medico, medicoID).Error; err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Médico no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar médico: "+err.Error())
+		}
+		return
+	}
+
+	// La ausencia se registra una sola vez aunque la operación se repita
+	var bloqueo *models.BloqueoAgenda
+	if input.BloquearAgenda == nil || *input.BloquearAgenda {
+		var existente models.BloqueoAgenda
+		err := tx.Where("medico_id = ? AND inicio <= ? AND fin >= ?", medico.ID, inicio, fin).First(&existente).Error
+		if err == gorm.ErrRecordNotFound {
+			nuevo := models.BloqueoAgenda{
+				MedicoID:    medico.ID,
+				Inicio:      inicio,
+				Fin:         fin,
+				DiaCompleto: input.DiaCompleto,
+				Motivo:      input.Motivo,
+				UsuarioID:   userID,
+			}
+			if err := tx.Create(&nuevo).Error; err != nil {
+				tx.Rollback()
+				respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar bloqueo: "+err.Error())
+				return
+			}
+			bloqueo = &nuevo
+		} else if err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar bloqueo: "+err.Error())
+			return
+		} else {
+			bloqueo = &existente
+		}
+	}
+
+	var citas []models.Cita
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("medico_id = ? AND estado IN ?", medico.ID, estadosReasignables).
+		Where("fecha_cita < ? AND fecha_fin > ?", fin, inicio).
+		Order("fecha_cita").
+		Find(&citas).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar citas afectadas: "+err.Error())
+		return
+	}
+
+	var buscador *buscadorEspacios
+	if input.Accion == accionReasignacionReprogramar {
+		medicos := []models.Medico{medico}
+		if input.Destino == destinoEspecialidad {
+			var otros []models.Medico
+			if err := tx.Preload("Usuario.Persona").
+				Where("especialidad = ? AND id <> ?", medico.Especialidad, medico.ID).
+				Find(&otros).Error; err != nil {
+				tx.Rollback()
+				respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar médicos de la especialidad: "+err.Error())
+				return
+			}
+			medicos = append(medicos, otros...)
+		}
+
+		desde := inicio.AddDate(0, 0, -input.DiasBusqueda)
+		if hoy := time.Now(); desde.Before(hoy) {
+			desde = hoy
+		}
+		buscador = &buscadorEspacios{
+			tx:        tx,
+			medicos:   medicos,
+			excluirID: medico.ID,
+			ausencia:  Slot{Inicio: inicio, Fin: fin},
+			desde:     time.Date(desde.Year(), desde.Month(), desde.Day(), 0, 0, 0, 0, time.Local),
+			hasta:     fin.AddDate(0, 0, input.DiasBusqueda),
+			slots:     map[uint]map[time.Duration][]Slot{},
+		}
+	}
+
+	reporte := []ResultadoReasignacion{}
+	for i := range citas {
+		cita := &citas[i]
+		resultado := ResultadoReasignacion{
+			CitaID:        cita.ID,
+			PacienteID:    cita.PacienteID,
+			FechaAnterior: cita.FechaCita,
+			MedicoID:      cita.MedicoID,
+		}
+
+		if buscador != nil {
+			reprogramada, err := reprogramarAlEspacioMasCercano(tx, buscador, cita, userID, input.Motivo)
+			if err != nil {
+				tx.Rollback()
+				respuestas.RespondError(c, http.StatusInternalServerError, "Error al reprogramar cita "+strconv.Itoa(int(cita.ID))+": "+err.Error())
+				return
+			}
+			if reprogramada {
+				resultado.Resultado = resultadoReprogramada
+				resultado.FechaNueva = &cita.FechaCita
+				resultado.MedicoID = cita.MedicoID
+				reporte = append(reporte, resultado)
+				continue
+			}
+
+			if !input.CancelarSinEspacio {
+				resultado.Resultado = resultadoSinEspacio
+				if err := avisarSinEspacio(tx, *cita, input.Motivo); err != nil {
+					tx.Rollback()
+					respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear notificación: "+err.Error())
+					return
+				}
+				reporte = append(reporte, resultado)
+				continue
+			}
+		}
+
+		if err := cancelarCitaReasignacion(tx, cita, userID, input.Motivo); err != nil {
+			tx.Rollback()
+			if errors.Is(err, models.ErrTransicionInvalida) {
+				responderErrorTransicion(c, err)
+				return
+			}
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al cancelar cita "+strconv.Itoa(int(cita.ID))+": "+err.Error())
+			return
+		}
+		resultado.Resultado = resultadoCancelada
+		reporte = append(reporte, resultado)
+	}
+
+	// En simulación se descarta todo: bloqueo, cambios y notificaciones
+	if input.Simular {
+		tx.Rollback()
+	} else if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	resumen := map[string]int{resultadoCancelada: 0, resultadoReprogramada: 0, resultadoSinEspacio: 0}
+	for _, resultado := range reporte {
+		resumen[resultado.Resultado]++
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"medico_id":  medico.ID,
+		"inicio":     inicio,
+		"fin":        fin,
+		"accion":     input.Accion,
+		"simulacion": input.Simular,
+		"bloqueo":    bloqueo,
+		"resumen":    resumen,
+		"citas":      reporte,
+	})
+}
+
+// reprogramarAlEspacioMasCercano mueve la cita al espacio libre más cercano que pase la validación
+// de agenda. Devuelve false si no encontró ninguno.
+func reprogramarAlEspacioMasCercano(tx *gorm.DB, buscador *buscadorEspacios, cita *models.Cita, userID uint, motivo string) (bool, error) {
+	slots, medicos, err := buscador.candidatos(cita.FechaCita, cita.Duracion())
+	if err != nil {
+		return false, err
+	}
+
+	for i, slot := range slots {
+		// Otra reserva pudo ocupar el espacio después de calcular la disponibilidad
+		if _, err := validarAgendaCita(tx, medicos[i], slot.Inicio, cita.Duracion(), cita.ID); err != nil {
+			if errors.Is(err, errCitaTraslapada) || errors.Is(err, errFueraDeHorario) ||
+				errors.Is(err, errAgendaBloqueada) || errors.Is(err, errDiaFestivo) {
+				buscador.ocupar(medicos[i], slot)
+				continue
+			}
+			return false, err
+		}
+
+		anterior := cita.FechaCita
+		cambioMedico := medicos[i] != cita.MedicoID
+		cita.FechaCita = slot.Inicio
+		cita.MedicoID = medicos[i]
+		if err := tx.Save(cita).Error; err != nil {
+			return false, err
+		}
+		buscador.ocupar(medicos[i], Slot{Inicio: cita.FechaCita, Fin: cita.FechaCita.Add(cita.Duracion())})
+
+		if cambioMedico {
+			for _, medico := range buscador.medicos {
+				if medico.ID == cita.MedicoID {
+					motivo += ". Le atenderá " + medico.Usuario.Persona.NombreCompleto()
+				}
+			}
+		}
+
+		return true, registrarReprogramacion(tx, *cita, anterior, userID, motivo)
+	}
+
+	return false, nil
+}
+
+// avisarSinEspacio pide al paciente que reprograme la cita que no se pudo mover. El aviso se envía
+// una sola vez por fecha de la cita aunque la operación se repita: lo garantiza aviso_sin_espacios.
+func avisarSinEspacio(tx *gorm.DB, cita models.Cita, motivo string) error {
+	aviso := models.AvisoSinEspacio{CitaID: cita.ID, FechaCita: cita.FechaCita}
+	resultado := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&aviso)
+	if resultado.Error != nil || resultado.RowsAffected == 0 {
+		return resultado.Error
+	}
+
+	notificacion := models.Notificacion{
+		IDUsuario: cita.PacienteID,
+		CitaID:    cita.ID,
+		Tipo:      "aviso",
+		Mensaje: "El médico no estará disponible para su cita del " + fechaLegible(cita.FechaCita) +
+			". Motivo: " + motivo + ". Por favor reprograme su cita",
+		FechaEnvio: time.Now(),
+	}
+	if err := tx.Create(&notificacion).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.AvisoSinEspacio{}).Where("id = ?", aviso.ID).Update("notificacion_id", notificacion.ID).Error
+}
+
+// cancelarCitaReasignacion cancela la cita por ausencia del médico y avisa al paciente. No se
+// ofrece el espacio a la lista de espera porque el médico no estará disponible.
+func cancelarCitaReasignacion(tx *gorm.DB, cita *models.Cita, userID uint, motivo string) error {
+	if err := cambiarEstadoCita(tx, cita, models.EstadoCancelada, "administrador", userID, motivo); err != nil {
+		return err
+	}
+
+	if err := tx.Model(cita).Updates(map[string]interface{}{
+		"motivo_cancelacion":     motivo,
+		"cancelacion_penalizada": false,
+	}).Error; err != nil {
+		return err
+	}
+
+	notificacion := models.Notificacion{
+		IDUsuario:  cita.PacienteID,
+		CitaID:     cita.ID,
+		Tipo:       "cancelación",
+		Mensaje:    "Su cita del " + fechaLegible(cita.FechaCita) + " fue cancelada porque el médico no estará disponible. Motivo: " + motivo,
+		FechaEnvio: time.Now(),
+	}
+	return tx.Create(&notificacion).Error
+}
@@ -0,0 +1,305 @@
+package controllers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Número de consultas recientes que se promedian para estimar tiempos de espera
+const consultasHistorialEspera = 50
+
+// EntradaCola es un paciente en la sala de espera de un médico
+type EntradaCola struct {
+	Posicion              int         `json:"posicion"`
+	Cita                  models.Cita `json:"cita"`
+	EsperaEstimadaMinutos int         `json:"espera_estimada_minutos"`
+	AtencionEstimada      time.Time   `json:"atencion_estimada"`
+}
+
+// ColaMedico es la sala de espera de un médico con la consulta en curso
+type ColaMedico struct {
+	MedicoID                uint          `json:"medico_id"`
+	DuracionPromedioMinutos int           `json:"duracion_promedio_minutos"`
+	EnConsulta              *models.Cita  `json:"en_consulta"`
+	Espera                  []EntradaCola `json:"espera"`
+}
+
+// duracionPromedioConsulta calcula la duración media de las últimas consultas del médico a partir
+// del historial de estados (de en_consulta a completada). Sin historial usa la duración de agenda.
+func duracionPromedioConsulta(db *gorm.DB, medico models.Medico) (time.Duration, error) {
+	var minutos sql.NullFloat64
+	err := db.Raw(`
+		SELECT AVG(EXTRACT(EPOCH FROM (fin.creado_en - inicio.creado_en)) / 60)
+		FROM (
+			SELECT inicio.cita_id, inicio.creado_en
+			FROM cambio_estado_cita inicio
+			JOIN cita ON cita.id = inicio.cita_id
+			WHERE inicio.estado_nuevo = ? AND cita.medico_id = ?
+			ORDER BY inicio.creado_en DESC
+			LIMIT ?
+		) inicio
+		JOIN cambio_estado_cita fin ON fin.cita_id = inicio.cita_id AND fin.estado_nuevo = ?`,
+		models.EstadoEnConsulta, medico.ID, consultasHistorialEspera, models.EstadoCompletada,
+	).Scan(&minutos).Error
+	if err != nil {
+		return 0, err
+	}
+
+	if minutos.Valid && minutos.Float64 > 0 {
+		return time.Duration(minutos.Float64 * float64(time.Minute)), nil
+	}
+
+	return duracionCitaMedico(db, medico)
+}
+
+// calcularColaMedico arma la sala de espera: pacientes en sala ordenados por hora de cita y de
+// llegada, con la espera estimada según la consulta en curso y la duración promedio del médico
+func calcularColaMedico(db *gorm.DB, medico models.Medico) (ColaMedico, error) {
+	cola := ColaMedico{MedicoID: medico.ID, Espera: []EntradaCola{}}
+
+	promedio, err := duracionPromedioConsulta(db, medico)
+	if err != nil {
+		return cola, err
+	}
+	cola.DuracionPromedioMinutos = int(promedio.Round(time.Minute) / time.Minute)
+
+	ahora := time.Now()
+	disponible := ahora
+
+	var enConsulta models.Cita
+	err = db.Preload("Paciente", sinContrasena).Preload("Paciente.Persona").
+		Preload("CambiosEstado", func(db *gorm.DB) *gorm.DB {
+			return db.Where("estado_nuevo = ?", models.EstadoEnConsulta).Order("creado_en")
+		}).
+		Where("medico_id = ? AND estado = ?", medico.ID, models.EstadoEnConsulta).
+		First(&enConsulta).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return cola, err
+	}
+	if err == nil {
+		cola.EnConsulta = &enConsulta
+		// Lo que resta de la consulta actual según el promedio
+		inicio := ahora
+		if len(enConsulta.CambiosEstado) > 0 {
+			inicio = enConsulta.CambiosEstado[len(enConsulta.CambiosEstado)-1].CreadoEn
+		}
+		if fin := inicio.Add(promedio); fin.After(ahora) {
+			disponible = fin
+		}
+	}
+
+	var enSala []models.Cita
+	if err := db.Preload("Paciente", sinContrasena).Preload("Paciente.Persona").Preload("TipoCita").
+		Where("medico_id = ? AND estado = ?", medico.ID, models.EstadoEnSala).
+		Order("fecha_cita, llegada_en").
+		Find(&enSala).Error; err != nil {
+		return cola, err
+	}
+
+	for i, cita := range enSala {
+		cola.Espera = append(cola.Espera, EntradaCola{
+			Posicion:              i + 1,
+			Cita:                  cita,
+			EsperaEstimadaMinutos: int(disponible.Sub(ahora).Round(time.Minute) / time.Minute),
+			AtencionEstimada:      disponible,
+		})
+		disponible = disponible.Add(promedio)
+	}
+
+	return cola, nil
+}
+
+// RegistrarLlegadaCita registra en recepción que el paciente llegó: marca la hora de llegada y
+// lo pasa a la sala de espera de su médico. Solo para citas del día.
+func RegistrarLlegadaCita(c *gin.Context) {
+	rol := c.GetString("userRol")
+	if rol != "recepcionista" && rol != "administrador" {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo recepción puede registrar llegadas")
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	var cita models.Cita
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&cita, id).Error; err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Cita no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar cita: "+err.Error())
+		}
+		return
+	}
+
+	ahora := time.Now()
+	fecha := cita.FechaCita.In(ahora.Location())
+	if fecha.YearDay() != ahora.YearDay() || fecha.Year() != ahora.Year() {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusBadRequest, "Solo se puede registrar la llegada a citas del día")
+		return
+	}
+
+	if err := cambiarEstadoCita(tx, &cita, models.EstadoEnSala, rol, c.GetUint("userID"), ""); err != nil {
+		tx.Rollback()
+		responderErrorTransicion(c, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	if err := initializers.GetDB().
+		Preload("Paciente", sinContrasena).
+		Preload("Paciente.Persona").
+		Preload("Medico").
+		Preload("Medico.Usuario", sinContrasena).
+		Preload("Medico.Usuario.Persona").
+		First(&cita, cita.ID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos actualizados: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, cita)
+}
+
+// GetColaMedico obtiene la sala de espera de un médico con tiempos de espera estimados
+func GetColaMedico(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	db := initializers.GetDB()
+
+	var medico models.Medico
+	if err := db.First(&medico, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Médico no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar médico: "+err.Error())
+		}
+		return
+	}
+
+	if !verificarAccesoMedico(c, db, medico.ID) {
+		return
+	}
+
+	cola, err := calcularColaMedico(db, medico)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener sala de espera: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, cola)
+}
+
+// LlamarSiguientePaciente pasa a consulta al primer paciente de la sala de espera del médico.
+// Falla si el médico aún tiene una consulta en curso.
+func LlamarSiguientePaciente(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	// Bloquear al médico para que dos llamadas simultáneas no pasen a dos pacientes
+	var medico models.Medico
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&medico, id).Error; err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Médico no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar médico: "+err.Error())
+		}
+		return
+	}
+
+	rol := c.GetString("userRol")
+	if rol != "medico" && rol != "administrador" {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusForbidden, "Solo el médico puede llamar al siguiente paciente")
+		return
+	}
+	if !verificarAccesoMedico(c, tx, medico.ID) {
+		tx.Rollback()
+		return
+	}
+
+	var enCurso int64
+	if err := tx.Model(&models.Cita{}).Where("medico_id = ? AND estado = ?", medico.ID, models.EstadoEnConsulta).Count(&enCurso).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar consulta en curso: "+err.Error())
+		return
+	}
+
+	if enCurso > 0 {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusConflict, "El médico tiene una consulta en curso, finalícela antes de llamar al siguiente")
+		return
+	}
+
+	var cita models.Cita
+	if err := tx.Where("medico_id = ? AND estado = ?", medico.ID, models.EstadoEnSala).
+		Order("fecha_cita, llegada_en").
+		First(&cita).Error; err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "No hay pacientes en la sala de espera")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar siguiente paciente: "+err.Error())
+		}
+		return
+	}
+
+	if err := cambiarEstadoCita(tx, &cita, models.EstadoEnConsulta, rol, c.GetUint("userID"), ""); err != nil {
+		tx.Rollback()
+		responderErrorTransicion(c, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	if err := initializers.GetDB().
+		Preload("Paciente", sinContrasena).
+		Preload("Paciente.Persona").
+		Preload("TipoCita").
+		First(&cita, cita.ID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos actualizados: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, cita)
+}
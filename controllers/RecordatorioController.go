@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// anticipacionesRecordatorio lee de RECORDATORIOS_HORAS (lista separada por comas, "48,2" por
+// defecto) con cuánta anticipación se recuerdan las citas, de mayor a menor. "0" los desactiva.
+func anticipacionesRecordatorio() []time.Duration {
+	valor := os.Getenv("RECORDATORIOS_HORAS")
+	if valor == "" {
+		valor = "48,2"
+	}
+
+	anticipaciones := []time.Duration{}
+	for _, parte := range strings.Split(valor, ",") {
+		if horas, err := strconv.Atoi(strings.TrimSpace(parte)); err == nil && horas > 0 {
+			anticipaciones = append(anticipaciones, time.Duration(horas)*time.Hour)
+		}
+	}
+	sort.Slice(anticipaciones, func(i, j int) bool { return anticipaciones[i] > anticipaciones[j] })
+	return anticipaciones
+}
+
+// enviarRecordatorios crea las notificaciones de recordatorio de las citas pendientes que ya
+// entraron en alguna de las anticipaciones configuradas. Cada cita se procesa en su propia
+// transacción y se bloquea con SKIP LOCKED, de modo que varias réplicas pueden ejecutarlo a la vez;
+// la tabla recordatorio_cita impide repetir un recordatorio tras un reinicio. Si a una cita le
+// tocan varios recordatorios a la vez (p. ej. se agendó con poca anticipación) solo se envía el más cercano.
+// Si falla el recordatorio de una cita se registra el error y se sigue con las demás.
+func enviarRecordatorios() (int, error) {
+	anticipaciones := anticipacionesRecordatorio()
+	if len(anticipaciones) == 0 {
+		return 0, nil
+	}
+
+	enviados := 0
+	fallidas := []uint{}
+	for {
+		ahora := time.Now()
+
+		// Alguna anticipación vencida sin registrar para la fecha actual de la cita
+		condiciones := make([]string, len(anticipaciones))
+		valores := []interface{}{}
+		for i, anticipacion := range anticipaciones {
+			condiciones[i] = `(cita.fecha_cita <= ? AND NOT EXISTS (SELECT 1 FROM recordatorio_cita r
+				WHERE r.cita_id = cita.id AND r.fecha_cita = cita.fecha_cita AND r.anticipacion_minutos = ?))`
+			valores = append(valores, ahora.Add(anticipacion), int(anticipacion.Minutes()))
+		}
+
+		procesada := false
+		var cita models.Cita
+		var pendientes []int
+		err := initializers.GetDB().Transaction(func(tx *gorm.DB) error {
+			consulta := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("cita.estado IN ? AND cita.fecha_cita > ?", models.EstadosCitaPendientes, ahora).
+				Where(strings.Join(condiciones, " OR "), valores...)
+			if len(fallidas) > 0 {
+				consulta = consulta.Where("cita.id NOT IN ?", fallidas)
+			}
+			err := consulta.Order("cita.fecha_cita").First(&cita).Error
+			if err == gorm.ErrRecordNotFound {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			procesada = true
+
+			var registradas []int
+			if err := tx.Model(&models.RecordatorioCita{}).
+				Where("cita_id = ? AND fecha_cita = ?", cita.ID, cita.FechaCita).
+				Pluck("anticipacion_minutos", &registradas).Error; err != nil {
+				return err
+			}
+
+			// Anticipaciones vencidas sin registrar, de mayor a menor
+			for _, anticipacion := range anticipaciones {
+				minutos := int(anticipacion.Minutes())
+				if cita.FechaCita.After(ahora.Add(anticipacion)) || contieneEntero(registradas, minutos) {
+					continue
+				}
+				pendientes = append(pendientes, minutos)
+			}
+			if len(pendientes) == 0 {
+				return nil
+			}
+
+			var medico models.Medico
+			if err := tx.Preload("Usuario.Persona").First(&medico, cita.MedicoID).Error; err != nil {
+				return err
+			}
+
+			mensaje := "Recordatorio: tiene una cita el " + fechaLegible(cita.FechaCita) +
+				" con " + medico.Usuario.Persona.NombreCompleto()
+			conSala, err := conSalaVirtual(tx, cita)
+			if err != nil {
+				return err
+			}
+
+			notificacion := models.Notificacion{
+				IDUsuario:  cita.PacienteID,
+				CitaID:     cita.ID,
+				Tipo:       "recordatorio",
+				Mensaje:    mensaje,
+				FechaEnvio: ahora,
+				ConSala:    conSala,
+			}
+			if err := tx.Create(&notificacion).Error; err != nil {
+				return err
+			}
+
+			for i, minutos := range pendientes {
+				recordatorio := models.RecordatorioCita{
+					CitaID:              cita.ID,
+					FechaCita:           cita.FechaCita,
+					AnticipacionMinutos: minutos,
+				}
+				// La notificación corresponde al recordatorio más cercano; los anteriores se omiten
+				if i == len(pendientes)-1 {
+					recordatorio.NotificacionID = &notificacion.ID
+				}
+				if err := tx.Create(&recordatorio).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if !procesada {
+			return enviados, err
+		}
+		if err != nil {
+			log.Printf("Error al enviar el recordatorio de la cita %d: %v", cita.ID, err)
+			fallidas = append(fallidas, cita.ID)
+			registrarRecordatorioFallido(cita, pendientes, err)
+			continue
+		}
+		enviados++
+	}
+}
+
+// registrarRecordatorioFallido guarda los recordatorios que no se pudieron enviar con su error, para
+// que no se reintenten en cada ejecución; la cita recibirá el siguiente recordatorio configurado.
+func registrarRecordatorioFallido(cita models.Cita, pendientes []int, causa error) {
+	for _, minutos := range pendientes {
+		recordatorio := models.RecordatorioCita{
+			CitaID:              cita.ID,
+			FechaCita:           cita.FechaCita,
+			AnticipacionMinutos: minutos,
+			Error:               causa.Error(),
+		}
+		err := initializers.GetDB().Clauses(clause.OnConflict{DoNothing: true}).Create(&recordatorio).Error
+		if err != nil {
+			log.Printf("Error al registrar el recordatorio fallido de la cita %d: %v", cita.ID, err)
+		}
+	}
+}
+
+// contieneEntero indica si el valor está en la lista
+func contieneEntero(lista []int, valor int) bool {
+	for _, v := range lista {
+		if v == valor {
+			return true
+		}
+	}
+	return false
+}
+
+// ProcesarRecordatorios envía periódicamente los recordatorios de citas. Se ejecuta en segundo plano.
+func ProcesarRecordatorios(intervalo time.Duration) {
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if n, err := enviarRecordatorios(); err != nil {
+			log.Println("Error al enviar recordatorios:", err)
+		} else if n > 0 {
+			log.Printf("Recordatorios de citas enviados: %d", n)
+		}
+	}
+}
+
+// EnviarRecordatorios ejecuta de inmediato el envío de recordatorios pendientes
+func EnviarRecordatorios(c *gin.Context) {
+	enviados, err := enviarRecordatorios()
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al enviar recordatorios: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"enviados": enviados})
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/canales"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+	"github.com/Ilimm9/CMedicas/videollamada"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// asignarSalaVirtual genera la sala de una cita por videollamada si aún no la tiene. Las citas
+// presenciales no tienen sala y devuelven nil.
+func asignarSalaVirtual(tx *gorm.DB, cita models.Cita) (*models.SalaVirtual, error) {
+	if cita.Modalidad != models.ModalidadVideollamada {
+		return nil, nil
+	}
+
+	var sala models.SalaVirtual
+	err := tx.Where("cita_id = ?", cita.ID).First(&sala).Error
+	if err == nil {
+		return &sala, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	url, err := videollamada.Actual().CrearSala(cita)
+	if err != nil {
+		return nil, err
+	}
+
+	sala = models.SalaVirtual{CitaID: cita.ID, URL: url}
+	if err := tx.Create(&sala).Error; err != nil {
+		return nil, err
+	}
+	return &sala, nil
+}
+
+// conSalaVirtual asegura la sala de una cita por videollamada e indica si la notificación debe
+// llevar el enlace. El enlace no se guarda en el mensaje: se agrega al entregarlo (ver textoEntrega).
+// Solo debe usarse en notificaciones dirigidas al paciente o al médico de la cita.
+func conSalaVirtual(tx *gorm.DB, cita models.Cita) (bool, error) {
+	sala, err := asignarSalaVirtual(tx, cita)
+	return sala != nil, err
+}
+
+// textoEntrega devuelve el mensaje que se envía por un canal. Si la notificación lleva sala, el
+// enlace se agrega solo en los canales propios del destinatario; el webhook es de la clínica y
+// nunca lo recibe.
+func textoEntrega(tx *gorm.DB, canal string, notificacion models.Notificacion) (string, error) {
+	if !notificacion.ConSala || canal == canales.Webhook {
+		return notificacion.Mensaje, nil
+	}
+
+	var sala models.SalaVirtual
+	err := tx.Where("cita_id = ?", notificacion.CitaID).First(&sala).Error
+	if err == gorm.ErrRecordNotFound {
+		return notificacion.Mensaje, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return notificacion.Mensaje + ". Enlace de videollamada: " + sala.URL, nil
+}
+
+// puedeVerSala indica si el usuario es el paciente o el médico de la cita; medicoID es el médico
+// asociado al usuario, o 0 si no tiene. El personal de la clínica no ve los enlaces.
+func puedeVerSala(cita models.Cita, rol string, usuarioID, medicoID uint) bool {
+	switch rol {
+	case "paciente":
+		return cita.PacienteID == usuarioID
+	case "medico":
+		return medicoID != 0 && cita.MedicoID == medicoID
+	}
+	return false
+}
+
+// GetSalaVirtualCita devuelve el enlace de videollamada de una cita. Solo lo pueden ver el
+// paciente y el médico de la cita.
+func GetSalaVirtualCita(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	db := initializers.GetDB()
+
+	var cita models.Cita
+	if err := db.First(&cita, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Cita no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar cita: "+err.Error())
+		}
+		return
+	}
+
+	rol := c.GetString("userRol")
+	var medicoID uint
+	if rol == "medico" {
+		if medico, err := medicoDeUsuario(db, c.GetUint("userID")); err == nil {
+			medicoID = medico.ID
+		}
+	}
+	if !puedeVerSala(cita, rol, c.GetUint("userID"), medicoID) {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo el paciente y el médico de la cita pueden ver el enlace de videollamada")
+		return
+	}
+
+	if cita.Modalidad != models.ModalidadVideollamada {
+		respuestas.RespondError(c, http.StatusNotFound, "La cita no es por videollamada")
+		return
+	}
+
+	sala, err := asignarSalaVirtual(db, cita)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener sala virtual: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, sala)
+}
@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/Ilimm9/CMedicas/models"
+)
+
+func TestPuedeVerSala(t *testing.T) {
+	cita := models.Cita{ID: 1, PacienteID: 10, MedicoID: 3}
+
+	casos := []struct {
+		nombre    string
+		rol       string
+		usuarioID uint
+		medicoID  uint
+		esperado  bool
+	}{
+		{"paciente de la cita", "paciente", 10, 0, true},
+		{"otro paciente", "paciente", 11, 0, false},
+		{"médico de la cita", "medico", 20, 3, true},
+		{"otro médico", "medico", 21, 4, false},
+		{"usuario médico sin registro de médico", "medico", 22, 0, false},
+		{"recepcionista", "recepcionista", 30, 0, false},
+		{"administrador", "administrador", 1, 0, false},
+		{"paciente con el ID del médico", "paciente", 3, 0, false},
+	}
+
+	for _, caso := range casos {
+		if got := puedeVerSala(cita, caso.rol, caso.usuarioID, caso.medicoID); got != caso.esperado {
+			t.Errorf("%s: se obtuvo %v, se esperaba %v", caso.nombre, got, caso.esperado)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Máximo de citas que puede generar una serie
+const maxCitasSerie = 52
+
+// Alcance de las operaciones sobre citas que pertenecen a una serie
+const (
+	alcanceEsta       = "esta"
+	alcanceSiguientes = "siguientes"
+	alcanceSerie      = "serie"
+)
+
+type SerieCitaInput struct {
+	CitaInput
+	Frecuencia   string     `json:"frecuencia" binding:"required,oneof=semanal quincenal"`
+	Repeticiones int        `json:"repeticiones" binding:"omitempty,min=2,max=52"`
+	Hasta        *time.Time `json:"hasta"` // Inclusive
+}
+
+func alcanceValido(alcance string) bool {
+	return alcance == alcanceEsta || alcance == alcanceSiguientes || alcance == alcanceSerie
+}
+
+// citasDeAlcance devuelve las citas afectadas por una operación sobre la cita indicada:
+// solo ella, ella y las posteriores de su serie, o todas las pendientes de la serie.
+// Para series solo se incluyen citas pendientes, ordenadas por fecha.
+func citasDeAlcance(tx *gorm.DB, cita models.Cita, alcance string) ([]models.Cita, error) {
+	if alcance == alcanceEsta || cita.SerieID == nil {
+		return []models.Cita{cita}, nil
+	}
+
+	query := tx.Where("serie_id = ? AND estado IN ?", *cita.SerieID, models.EstadosCitaPendientes)
+	if alcance == alcanceSiguientes {
+		query = query.Where("fecha_cita >= ?", cita.FechaCita)
+	} else {
+		query = query.Where("fecha_cita >= ?", time.Now())
+	}
+
+	var citas []models.Cita
+	err := query.Order("fecha_cita").Find(&citas).Error
+	return citas, err
+}
+
+// fechasSerie genera las fechas de las citas de una serie a partir de su regla de recurrencia
+func fechasSerie(inicio time.Time, dias int, repeticiones int, hasta *time.Time) ([]time.Time, error) {
+	inicio = inicio.In(time.Local)
+	fechas := []time.Time{}
+
+	for i := 0; ; i++ {
+		// AddDate conserva la hora local aunque cambie el horario de verano
+		fecha := inicio.AddDate(0, 0, i*dias)
+		if repeticiones > 0 && i >= repeticiones {
+			break
+		}
+		if hasta != nil && fecha.After(*hasta) {
+			break
+		}
+		if i >= maxCitasSerie {
+			return nil, errors.New("La serie no puede tener más de " + strconv.Itoa(maxCitasSerie) + " citas")
+		}
+		fechas = append(fechas, fecha)
+	}
+
+	return fechas, nil
+}
+
+// PostSerieCita crea una serie de citas recurrentes validando cada una contra la agenda del médico.
+// Si alguna fecha no está disponible no se crea ninguna y se devuelven los conflictos.
+func PostSerieCita(c *gin.Context) {
+	var input SerieCitaInput
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if (input.Repeticiones == 0) == (input.Hasta == nil) {
+		respuestas.RespondError(c, http.StatusBadRequest, "Indique el número de repeticiones o la fecha límite de la serie (solo uno)")
+		return
+	}
+
+	base, ok := nuevaCita(c, input.CitaInput)
+	if !ok {
+		return
+	}
+
+	if base.Estado, ok = estadoInicialCita(c, initializers.GetDB(), base.PacienteID); !ok {
+		return
+	}
+
+	serie := models.SerieCita{
+		PacienteID:   input.PacienteID,
+		MedicoID:     input.MedicoID,
+		Frecuencia:   input.Frecuencia,
+		Repeticiones: input.Repeticiones,
+		Hasta:        input.Hasta,
+	}
+
+	fechas, err := fechasSerie(input.FechaCita, serie.DiasIntervalo(), input.Repeticiones, input.Hasta)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if len(fechas) < 2 {
+		respuestas.RespondError(c, http.StatusBadRequest, "La serie debe generar al menos 2 citas")
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	// Validar todas las fechas antes de crear nada
+	conflictos := []gin.H{}
+	for _, fecha := range fechas {
+		conflicto, err := validarAgendaCita(tx, base.MedicoID, fecha, base.Duracion())
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, errCitaTraslapada) && !errors.Is(err, errFueraDeHorario) {
+			tx.Rollback()
+			responderErrorAgenda(c, conflicto, err)
+			return
+		}
+		conflictos = append(conflictos, gin.H{
+			"fecha_cita":     fecha,
+			"error":          err.Error(),
+			"cita_conflicto": conflicto,
+		})
+	}
+
+	if len(conflictos) > 0 {
+		tx.Rollback()
+		respuestas.RespondErrorData(c, http.StatusConflict, "Algunas fechas de la serie no están disponibles", gin.H{"conflictos": conflictos})
+		return
+	}
+
+	// Todas las citas de la serie cuentan para los límites de citas activas del paciente
+	if !verificarLimitesCitas(c, tx, base, len(fechas)) {
+		tx.Rollback()
+		return
+	}
+
+	if err := tx.Create(&serie).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar serie: "+err.Error())
+		return
+	}
+
+	for _, fecha := range fechas {
+		cita := base
+		cita.FechaCita = fecha
+		cita.SerieID = &serie.ID
+
+		if err := tx.Create(&cita).Error; err != nil {
+			tx.Rollback()
+			if esViolacionDeAgenda(err) {
+				respuestas.RespondError(c, http.StatusConflict, errCitaTraslapada.Error())
+				return
+			}
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar cita: "+err.Error())
+			return
+		}
+
+		if _, err := asignarSalaVirtual(tx, cita); err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear sala virtual: "+err.Error())
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	if err := cargarSerieCita(&serie, serie.ID); err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos de la serie: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, serie)
+}
+
+// cargarSerieCita carga una serie con sus citas ordenadas por fecha
+func cargarSerieCita(serie *models.SerieCita, id uint) error {
+	return initializers.GetDB().
+		Preload("Citas", func(db *gorm.DB) *gorm.DB { return db.Order("fecha_cita") }).
+		Preload("Citas.TipoCita").
+		First(serie, id).Error
+}
+
+// GetSerieCita obtiene una serie con todas sus citas
+func GetSerieCita(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var serie models.SerieCita
+	if err := cargarSerieCita(&serie, uint(id)); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Serie no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar serie: "+err.Error())
+		}
+		return
+	}
+
+	// Solo el paciente, su médico o un administrador pueden ver la serie
+	userID := c.GetUint("userID")
+	switch c.GetString("userRol") {
+	case "administrador":
+	case "medico":
+		var medico models.Medico
+		if err := initializers.GetDB().Where("usuario_id = ?", userID).First(&medico).Error; err != nil || medico.ID != serie.MedicoID {
+			respuestas.RespondError(c, http.StatusForbidden, "No tienes permiso para ver esta serie")
+			return
+		}
+	default:
+		if serie.PacienteID != userID {
+			respuestas.RespondError(c, http.StatusForbidden, "No tienes permiso para ver esta serie")
+			return
+		}
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, serie)
+}
+
+// ReprogramarSerieCita mueve una cita de una serie a una nueva fecha. Con alcance siguientes o
+// serie el mismo desplazamiento se aplica a las demás citas afectadas, validando cada una. Los
+// permisos y la anticipación son los mismos que en ReprogramarCita.
+func ReprogramarSerieCita(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var input struct {
+		FechaCita time.Time `json:"fecha_cita" binding:"required"`
+		Alcance   string    `json:"alcance" binding:"omitempty,oneof=esta siguientes serie"`
+		Motivo    string    `json:"motivo" binding:"max=500"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if input.Alcance == "" {
+		input.Alcance = alcanceEsta
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	// Bloquear las citas para que una cancelación o cambio de estado concurrente no se cruce
+	bloqueo := tx.Clauses(clause.Locking{Strength: "UPDATE"})
+
+	var cita models.Cita
+	if err := bloqueo.First(&cita, id).Error; err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Cita no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar cita: "+err.Error())
+		}
+		return
+	}
+
+	// Mismas reglas que para reprogramar una sola cita
+	if !verificarReprogramacion(c, tx, cita) {
+		tx.Rollback()
+		return
+	}
+
+	if !models.EstadoPendiente(cita.Estado) {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusBadRequest, "Solo se pueden reprogramar citas que no han comenzado")
+		return
+	}
+
+	citas, err := citasDeAlcance(bloqueo, cita, input.Alcance)
+	if err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener citas de la serie: "+err.Error())
+		return
+	}
+
+	// La anticipación se exige a cada una de las citas que se mueven
+	for i := range citas {
+		if citas[i].ID != cita.ID && !verificarReprogramacion(c, tx, citas[i]) {
+			tx.Rollback()
+			return
+		}
+	}
+
+	desplazamiento := input.FechaCita.Sub(cita.FechaCita)
+	ids := make([]uint, len(citas))
+	anteriores := map[uint]time.Time{}
+	for i := range citas {
+		ids[i] = citas[i].ID
+		anteriores[citas[i].ID] = citas[i].FechaCita
+	}
+
+	// Validar las nuevas fechas ignorando las citas que se van a mover
+	for i := range citas {
+		citas[i].FechaCita = citas[i].FechaCita.Add(desplazamiento)
+		if citas[i].FechaCita.Before(time.Now()) {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusBadRequest, "La fecha de la cita debe ser futura")
+			return
+		}
+		if conflicto, err := validarAgendaCita(tx, citas[i].MedicoID, citas[i].FechaCita, citas[i].Duracion(), ids...); err != nil {
+			tx.Rollback()
+			responderErrorAgenda(c, conflicto, err)
+			return
+		}
+	}
+
+	// Guardar empezando por el extremo hacia el que se mueven para no traslaparlas entre sí
+	sort.Slice(citas, func(i, j int) bool {
+		if desplazamiento > 0 {
+			return citas[i].FechaCita.After(citas[j].FechaCita)
+		}
+		return citas[i].FechaCita.Before(citas[j].FechaCita)
+	})
+	for i := range citas {
+		citas[i].FechaFin = citas[i].FechaCita.Add(citas[i].Duracion())
+		if err := tx.Model(&citas[i]).Updates(map[string]interface{}{
+			"fecha_cita": citas[i].FechaCita,
+			"fecha_fin":  citas[i].FechaFin,
+		}).Error; err != nil {
+			tx.Rollback()
+			if esViolacionDeAgenda(err) {
+				respuestas.RespondError(c, http.StatusConflict, errCitaTraslapada.Error())
+				return
+			}
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al reprogramar cita: "+err.Error())
+			return
+		}
+
+		if err := registrarReprogramacion(tx, citas[i], anteriores[citas[i].ID], c.GetUint("userID"), input.Motivo); err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al registrar reprogramación: "+err.Error())
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	sort.Slice(citas, func(i, j int) bool { return citas[i].FechaCita.Before(citas[j].FechaCita) })
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"message": "Cita reprogramada exitosamente",
+		"citas":   citas,
+	})
+}
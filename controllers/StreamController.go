@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/clave"
+	"github.com/Ilimm9/CMedicas/eventos"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Antigüedad máxima de los eventos que se recuperan al reanudar con Last-Event-ID
+const ventanaReanudacion = 24 * time.Hour
+
+// Al reanudar se reenvían también los eventos creados hasta este tiempo antes del último recibido:
+// los IDs se asignan al insertar, y un evento de una transacción más lenta puede confirmarse
+// después de otro con un ID mayor
+const margenReanudacion = 2 * time.Minute
+
+// Cada cuánto se envía un comentario para que proxies y navegadores no cierren la conexión
+const latidoStream = 25 * time.Second
+
+// eventosPendientes devuelve los eventos que le corresponden al suscriptor desde el margen de
+// reanudación antes de ultimoID, dentro de la ventana de reanudación. Pueden incluir eventos que
+// el cliente ya recibió; el cliente los descarta por su ID.
+func eventosPendientes(db *gorm.DB, suscriptor eventos.Suscriptor, ultimoID uint) ([]eventos.Evento, error) {
+	pendientes := []eventos.Evento{}
+
+	desde := time.Now().Add(-ventanaReanudacion)
+	var ultimo models.EventoOutbox
+	err := db.Select("id", "creado_en").First(&ultimo, ultimoID).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	if err == nil && ultimo.CreadoEn.Add(-margenReanudacion).After(desde) {
+		desde = ultimo.CreadoEn.Add(-margenReanudacion)
+	}
+
+	var lote []models.EventoOutbox
+	err = db.
+		Where("id <> ? AND tipo IN ? AND creado_en >= ?", ultimoID, eventos.TiposPublicados, desde).
+		Order("id").
+		FindInBatches(&lote, 500, func(tx *gorm.DB, _ int) error {
+			for _, evento := range lote {
+				e, err := eventos.DesdeOutbox(evento)
+				if err != nil {
+					return err
+				}
+				if suscriptor.Recibe(e) {
+					pendientes = append(pendientes, e)
+				}
+			}
+			return nil
+		}).Error
+	return pendientes, err
+}
+
+// escribirEvento envía un evento con su ID, para que el cliente pueda reanudar desde él
+func escribirEvento(c *gin.Context, e eventos.Evento) {
+	c.Render(-1, sse.Event{
+		Id:    strconv.FormatUint(uint64(e.ID), 10),
+		Event: e.Tipo,
+		Data:  e.Datos,
+	})
+}
+
+// PostTicketStream entrega un ticket de un minuto para abrir el stream con ?ticket=, desde
+// clientes que no pueden enviar el encabezado Authorization (EventSource del navegador)
+func PostTicketStream(c *gin.Context) {
+	ticket, err := clave.GenerateStreamTicket(c.GetUint("userID"), c.GetString("userRol"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar ticket: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, gin.H{
+		"ticket":    ticket,
+		"expira_en": time.Now().Add(clave.VigenciaTicketStream),
+	})
+}
+
+// GetStream abre un canal Server-Sent Events con las notificaciones nuevas del usuario actual y
+// los cambios de estado y reprogramaciones de las citas que le corresponden: las propias para
+// pacientes y médicos, y todas para recepción y administración. Al reconectarse, el navegador
+// envía Last-Event-ID (o el cliente puede pasar ?ultimo_evento=) y se reenvían los eventos
+// perdidos de las últimas 24 horas, junto con algunos ya enviados que el cliente descarta por ID.
+func GetStream(c *gin.Context) {
+	db := initializers.GetDB()
+
+	suscriptor := eventos.Suscriptor{UsuarioID: c.GetUint("userID"), Rol: c.GetString("userRol")}
+	if suscriptor.Rol == "medico" {
+		medico, err := medicoDeUsuario(db, suscriptor.UsuarioID)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusNotFound, "No se encontró médico asociado a este usuario")
+			return
+		}
+		suscriptor.MedicoID = medico.ID
+	}
+
+	ultimo := c.GetHeader("Last-Event-ID")
+	if ultimo == "" {
+		ultimo = c.Query("ultimo_evento")
+	}
+	var ultimoID uint64
+	if ultimo != "" {
+		var err error
+		if ultimoID, err = strconv.ParseUint(ultimo, 10, 64); err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Last-Event-ID inválido")
+			return
+		}
+	}
+
+	// Suscribirse antes de leer los pendientes para no perder los que lleguen entre medio
+	suscripcion := eventos.Suscribir(suscriptor)
+	defer suscripcion.Cancelar()
+
+	enviados := map[uint]bool{}
+	var pendientes []eventos.Evento
+	if ultimo != "" {
+		var err error
+		pendientes, err = eventosPendientes(db, suscriptor, uint(ultimoID))
+		if err != nil {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al recuperar eventos: "+err.Error())
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	for _, e := range pendientes {
+		escribirEvento(c, e)
+		enviados[e.ID] = true
+	}
+	io.WriteString(c.Writer, ": conectado\n\n")
+	c.Writer.Flush()
+
+	latido := time.NewTicker(latidoStream)
+	defer latido.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case e, ok := <-suscripcion.Eventos:
+			// Canal cerrado: el cliente se atrasó y debe reconectarse con Last-Event-ID
+			if !ok {
+				return false
+			}
+			if !enviados[e.ID] {
+				escribirEvento(c, e)
+			}
+			return true
+		case <-latido.C:
+			io.WriteString(w, ": latido\n\n")
+			return true
+		}
+	})
+}
@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TipoCitaInput struct {
+	Nombre          string   `json:"nombre" binding:"required,max=100"`
+	DuracionMinutos int      `json:"duracion_minutos" binding:"required,min=5,max=480"`
+	Precio          float64  `json:"precio" binding:"min=0"`
+	Especialidades  []string `json:"especialidades" binding:"dive,max=100"` // Vacío = todas las especialidades
+}
+
+// especialidadesTipoCita convierte la lista de nombres en registros de la relación
+func especialidadesTipoCita(nombres []string) []models.TipoCitaEspecialidad {
+	especialidades := []models.TipoCitaEspecialidad{}
+	vistas := map[string]bool{}
+	for _, nombre := range nombres {
+		if nombre == "" || vistas[nombre] {
+			continue
+		}
+		vistas[nombre] = true
+		especialidades = append(especialidades, models.TipoCitaEspecialidad{Especialidad: nombre})
+	}
+	return especialidades
+}
+
+// PostTipoCita crea un tipo de cita en el catálogo
+func PostTipoCita(c *gin.Context) {
+	var input TipoCitaInput
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	tipo := models.TipoCita{
+		Nombre:          input.Nombre,
+		DuracionMinutos: input.DuracionMinutos,
+		Precio:          input.Precio,
+		Activo:          true,
+		Especialidades:  especialidadesTipoCita(input.Especialidades),
+	}
+
+	if err := tx.Create(&tipo).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar tipo de cita: "+err.Error())
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, tipo)
+}
+
+// GetAllTiposCita obtiene el catálogo, opcionalmente filtrado por especialidad
+func GetAllTiposCita(c *gin.Context) {
+	var tipos []models.TipoCita
+	query := initializers.GetDB().Preload("Especialidades").Order("nombre")
+
+	// Los inactivos solo se incluyen si se piden explícitamente
+	if c.Query("incluir_inactivos") != "true" {
+		query = query.Where("activo = ?", true)
+	}
+
+	if err := query.Find(&tipos).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener tipos de cita: "+err.Error())
+		return
+	}
+
+	if especialidad := c.Query("especialidad"); especialidad != "" {
+		filtrados := []models.TipoCita{}
+		for _, tipo := range tipos {
+			if tipo.OfrecidoPor(especialidad) {
+				filtrados = append(filtrados, tipo)
+			}
+		}
+		tipos = filtrados
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, tipos)
+}
+
+// GetTipoCita obtiene un tipo de cita por ID
+func GetTipoCita(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var tipo models.TipoCita
+	if err := initializers.GetDB().Preload("Especialidades").First(&tipo, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Tipo de cita no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar tipo de cita: "+err.Error())
+		}
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, tipo)
+}
+
+// UpdateTipoCita actualiza un tipo de cita; si se envían especialidades reemplazan a las actuales
+func UpdateTipoCita(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var input struct {
+		Nombre          string    `json:"nombre" binding:"max=100"`
+		DuracionMinutos int       `json:"duracion_minutos" binding:"omitempty,min=5,max=480"`
+		Precio          *float64  `json:"precio" binding:"omitempty,min=0"`
+		Activo          *bool     `json:"activo"`
+		Especialidades  *[]string `json:"especialidades"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	var tipo models.TipoCita
+	if err := tx.First(&tipo, id).Error; err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Tipo de cita no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar tipo de cita: "+err.Error())
+		}
+		return
+	}
+
+	// Actualizar solo los campos proporcionados
+	if input.Nombre != "" {
+		tipo.Nombre = input.Nombre
+	}
+	if input.DuracionMinutos != 0 {
+		tipo.DuracionMinutos = input.DuracionMinutos
+	}
+	if input.Precio != nil {
+		tipo.Precio = *input.Precio
+	}
+	if input.Activo != nil {
+		tipo.Activo = *input.Activo
+	}
+
+	if err := tx.Save(&tipo).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar tipo de cita: "+err.Error())
+		return
+	}
+
+	if input.Especialidades != nil {
+		if err := tx.Where("tipo_cita_id = ?", tipo.ID).Delete(&models.TipoCitaEspecialidad{}).Error; err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar especialidades: "+err.Error())
+			return
+		}
+		especialidades := especialidadesTipoCita(*input.Especialidades)
+		for i := range especialidades {
+			especialidades[i].TipoCitaID = tipo.ID
+		}
+		if len(especialidades) > 0 {
+			if err := tx.Create(&especialidades).Error; err != nil {
+				tx.Rollback()
+				respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar especialidades: "+err.Error())
+				return
+			}
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	if err := initializers.GetDB().Preload("Especialidades").First(&tipo, tipo.ID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos actualizados: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, tipo)
+}
+
+// DeleteTipoCita elimina un tipo de cita que no tenga citas asociadas
+func DeleteTipoCita(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	var count int64
+	if err := tx.Model(&models.Cita{}).Where("tipo_cita_id = ?", id).Count(&count).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar citas: "+err.Error())
+		return
+	}
+
+	if count > 0 {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusBadRequest, "No se puede eliminar, el tipo tiene citas asociadas. Desactívelo en su lugar")
+		return
+	}
+
+	result := tx.Delete(&models.TipoCita{}, id)
+	if result.Error != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al eliminar tipo de cita: "+result.Error.Error())
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusNotFound, "Tipo de cita no encontrado")
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Tipo de cita eliminado correctamente"})
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/Ilimm9/CMedicas/clave"
 	"github.com/Ilimm9/CMedicas/initializers"
 	"github.com/Ilimm9/CMedicas/models"
+	"github.com/Ilimm9/CMedicas/paginacion"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -16,11 +17,16 @@ import (
 
 type UsuarioInput struct {
 	PersonaID  uint   `json:"persona_id" binding:"required"`
-	Rol        string `json:"rol" binding:"required,oneof=paciente medico administrador"`
+	Rol        string `json:"rol" binding:"required,oneof=paciente medico recepcionista administrador"`
 	Correo     string `json:"correo" binding:"required,email"`
 	Contrasena string `json:"contrasena" binding:"required,min=8"`
 }
 
+// sinContrasena precarga un usuario sin leer el hash de su contraseña
+func sinContrasena(db *gorm.DB) *gorm.DB {
+	return db.Omit("Contrasena")
+}
+
 // Crear nuevo usuario
 func PostUsuario(c *gin.Context) {
 	var input UsuarioInput
@@ -187,9 +193,9 @@ func GetUsuario(c *gin.Context) {
 // Obtener todos los usuarios
 func GetAllUsuarios(c *gin.Context) {
 	var usuarios []models.Usuario
-	result := initializers.GetDB().Preload("Persona").Find(&usuarios)
-	if result.Error != nil {
-		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener usuarios: "+result.Error.Error())
+	meta, err := paginacion.Paginar(c, initializers.GetDB(), listadoUsuarios, &usuarios)
+	if err != nil {
+		responderErrorListado(c, "Error al obtener usuarios: ", err)
 		return
 	}
 
@@ -198,7 +204,25 @@ func GetAllUsuarios(c *gin.Context) {
 		usuarios[i].Contrasena = ""
 	}
 
-	respuestas.RespondSuccess(c, http.StatusOK, usuarios)
+	respuestas.RespondSuccessPaginado(c, http.StatusOK, usuarios, meta)
+}
+
+// Parámetros admitidos por el listado de usuarios
+var listadoUsuarios = paginacion.Listado[models.Usuario]{
+	Tabla: "usuarios",
+	ID:    func(usuario models.Usuario) uint { return usuario.ID },
+	Orden: map[string]paginacion.Orden[models.Usuario]{
+		"id":        {Columna: "usuarios.id", Valor: func(usuario models.Usuario) any { return usuario.ID }},
+		"correo":    {Columna: "usuarios.correo", Valor: func(usuario models.Usuario) any { return usuario.Correo }},
+		"creado_en": {Columna: "usuarios.creado_en", Valor: func(usuario models.Usuario) any { return usuario.CreadoEn }},
+	},
+	OrdenPorDefecto: "id",
+	Filtros: map[string]string{
+		"rol": "usuarios.rol",
+	},
+	CampoFecha: "usuarios.creado_en",
+	Busqueda:   []string{"usuarios.correo"},
+	Preload:    []string{"Persona"},
 }
 
 // Actualizar usuario
@@ -210,7 +234,7 @@ func UpdateUsuario(c *gin.Context) {
 	}
 
 	var input struct {
-		Rol        string `json:"rol" binding:"omitempty,oneof=paciente medico administrador"`
+		Rol        string `json:"rol" binding:"omitempty,oneof=paciente medico recepcionista administrador"`
 		Correo     string `json:"correo" binding:"omitempty,email"`
 		Contrasena string `json:"contrasena" binding:"omitempty,min=8"`
 	}
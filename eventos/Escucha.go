@@ -0,0 +1,85 @@
+package eventos
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Espera antes de reconectar tras perder la conexión de escucha
+const esperaReconexion = 5 * time.Second
+
+// aviso es el contenido del NOTIFY que emite models.RegistrarEvento
+type aviso struct {
+	ID   uint   `json:"id"`
+	Tipo string `json:"tipo"`
+}
+
+// Escuchar recibe por LISTEN los avisos de eventos nuevos de todas las instancias y los publica
+// en las suscripciones de esta. Usa una conexión propia, fuera del pool de GORM, y se reconecta
+// si la pierde. Se ejecuta en segundo plano.
+func Escuchar() {
+	for {
+		if err := escuchar(context.Background()); err != nil {
+			log.Println("Error en la escucha de eventos:", err)
+		}
+		time.Sleep(esperaReconexion)
+	}
+}
+
+func escuchar(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, initializers.DSN())
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+models.CanalEventos); err != nil {
+		return err
+	}
+
+	for {
+		notificacion, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var recibido aviso
+		if err := json.Unmarshal([]byte(notificacion.Payload), &recibido); err != nil {
+			log.Println("Aviso de evento inválido:", notificacion.Payload)
+			continue
+		}
+		if !publicado(recibido.Tipo) {
+			continue
+		}
+
+		var evento models.EventoOutbox
+		if err := initializers.GetDB().First(&evento, recibido.ID).Error; err != nil {
+			log.Println("Error al cargar evento del outbox:", err)
+			continue
+		}
+
+		e, err := DesdeOutbox(evento)
+		if err != nil {
+			log.Println("Error al leer datos del evento:", err)
+			continue
+		}
+		Publicar(e)
+	}
+}
+
+// publicado indica si el tipo de evento se envía a los clientes
+func publicado(tipo string) bool {
+	for _, t := range TiposPublicados {
+		if t == tipo {
+			return true
+		}
+	}
+	return false
+}
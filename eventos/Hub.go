@@ -0,0 +1,124 @@
+package eventos
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/Ilimm9/CMedicas/models"
+)
+
+// Eventos del outbox que se envían a los clientes conectados
+var TiposPublicados = []string{
+	models.EventoNotificacionCreada,
+	models.EventoCitaEstado,
+	models.EventoCitaReprogramada,
+}
+
+// Tamaño del búfer de cada suscripción. Un cliente que se atrasa más se desconecta y al
+// reconectarse recupera lo pendiente con Last-Event-ID.
+const bufferSuscripcion = 64
+
+// Evento es un evento del outbox listo para enviarse, con los interesados ya extraídos de sus datos
+type Evento struct {
+	ID         uint
+	Tipo       string
+	Datos      json.RawMessage
+	UsuarioID  uint // Destinatario, en las notificaciones
+	PacienteID uint
+	MedicoID   uint
+}
+
+// DesdeOutbox prepara un evento del outbox para enviarlo
+func DesdeOutbox(evento models.EventoOutbox) (Evento, error) {
+	var interesados struct {
+		UsuarioID  uint `json:"usuario_id"`
+		PacienteID uint `json:"paciente_id"`
+		MedicoID   uint `json:"medico_id"`
+	}
+	if err := json.Unmarshal([]byte(evento.Datos), &interesados); err != nil {
+		return Evento{}, err
+	}
+
+	return Evento{
+		ID:         evento.ID,
+		Tipo:       evento.Tipo,
+		Datos:      json.RawMessage(evento.Datos),
+		UsuarioID:  interesados.UsuarioID,
+		PacienteID: interesados.PacienteID,
+		MedicoID:   interesados.MedicoID,
+	}, nil
+}
+
+// Suscriptor identifica a quién está conectado. MedicoID solo se usa con el rol medico.
+type Suscriptor struct {
+	UsuarioID uint
+	Rol       string
+	MedicoID  uint
+}
+
+// Recibe indica si el evento le corresponde: las notificaciones solo a su destinatario, y los
+// cambios de citas al paciente, al médico de la cita y al personal de recepción y administración
+func (s Suscriptor) Recibe(e Evento) bool {
+	if e.Tipo == models.EventoNotificacionCreada {
+		return e.UsuarioID == s.UsuarioID
+	}
+
+	switch s.Rol {
+	case "recepcionista", "administrador":
+		return true
+	case "medico":
+		return s.MedicoID != 0 && e.MedicoID == s.MedicoID
+	}
+	return e.PacienteID == s.UsuarioID
+}
+
+// Suscripcion recibe los eventos de un cliente conectado. El canal se cierra si el cliente se
+// atrasa; en ese caso debe reconectarse.
+type Suscripcion struct {
+	Eventos    <-chan Evento
+	eventos    chan Evento
+	suscriptor Suscriptor
+}
+
+var (
+	mu            sync.Mutex
+	suscripciones = map[*Suscripcion]struct{}{}
+)
+
+// Suscribir empieza a recibir los eventos que le corresponden al suscriptor
+func Suscribir(suscriptor Suscriptor) *Suscripcion {
+	eventos := make(chan Evento, bufferSuscripcion)
+	s := &Suscripcion{Eventos: eventos, eventos: eventos, suscriptor: suscriptor}
+
+	mu.Lock()
+	suscripciones[s] = struct{}{}
+	mu.Unlock()
+	return s
+}
+
+// Cancelar deja de recibir eventos
+func (s *Suscripcion) Cancelar() {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := suscripciones[s]; ok {
+		delete(suscripciones, s)
+		close(s.eventos)
+	}
+}
+
+// Publicar entrega el evento a las suscripciones de esta instancia a las que les corresponde
+func Publicar(e Evento) {
+	mu.Lock()
+	defer mu.Unlock()
+	for s := range suscripciones {
+		if !s.suscriptor.Recibe(e) {
+			continue
+		}
+		select {
+		case s.eventos <- e:
+		default:
+			delete(suscripciones, s)
+			close(s.eventos)
+		}
+	}
+}
@@ -11,17 +11,21 @@ import (
 
 var DB *gorm.DB
 
-func ConnectDB() {
-    var err error
-
+// DSN arma la cadena de conexión a partir de las variables de entorno
+func DSN() string {
     user := os.Getenv("DB_USER")
     password := os.Getenv("DB_PASSWORD")
     host := os.Getenv("DB_HOST")
     port := os.Getenv("DB_PORT")
     dbname := os.Getenv("DB_NAME")
 
-    dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=require", host, user, password, dbname, port)
-    DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+    return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=require", host, user, password, dbname, port)
+}
+
+func ConnectDB() {
+    var err error
+
+    DB, err = gorm.Open(postgres.Open(DSN()), &gorm.Config{})
 
     if err != nil {
         log.Fatal("Fallo la conexion con la base de datos")
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"github.com/Ilimm9/CMedicas/controllers"
+	"github.com/Ilimm9/CMedicas/eventos"
 	"github.com/Ilimm9/CMedicas/initializers"
 	"github.com/Ilimm9/CMedicas/migrate"
 	"github.com/Ilimm9/CMedicas/routes"
@@ -23,7 +25,7 @@ func main() {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:4200"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Last-Event-ID"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		MaxAge: 12 * time.Hour,
@@ -32,5 +34,12 @@ func main() {
 	// Rutas
 	routes.AdminRutas(r)
 
+	// Tareas en segundo plano
+	go controllers.ProcesarListaEspera(time.Minute)
+	go controllers.ProcesarInasistencias(5 * time.Minute)
+	go controllers.ProcesarRecordatorios(time.Minute)
+	go controllers.ProcesarOutbox(15 * time.Second)
+	go eventos.Escuchar()
+
 	r.Run()
 }
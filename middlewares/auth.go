@@ -24,22 +24,56 @@ func AuthMiddleware() gin.HandlerFunc {
 			tokenString = tokenString[7:]
 		}
 
-		token, err := clave.ValidateJWT(tokenString)
-		if err != nil {
-			respuestas.RespondError(c, http.StatusUnauthorized, "Token inválido: "+err.Error())
+		autenticar(c, tokenString, "")
+	}
+}
+
+// autenticar valida el token y guarda al usuario en el contexto. uso es el propósito que debe
+// declarar el token: vacío para el token de sesión, o el de un ticket como clave.UsoStream.
+func autenticar(c *gin.Context, tokenString string, uso string) {
+	token, err := clave.ValidateJWT(tokenString)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Token inválido: "+err.Error())
+		c.Abort()
+		return
+	}
+
+	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+		// Un ticket no sirve como token de sesión ni al revés
+		if usoToken, _ := claims["uso"].(string); usoToken != uso {
+			respuestas.RespondError(c, http.StatusUnauthorized, "Token inválido")
 			c.Abort()
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			// Guardar información del usuario en el contexto
-			c.Set("userID", claims["sub"])
-			c.Set("userRol", claims["rol"])
-			c.Next()
-		} else {
+		// Los números del JWT llegan como float64, se guardan como uint para compararlos con los IDs
+		sub, ok := claims["sub"].(float64)
+		if !ok {
 			respuestas.RespondError(c, http.StatusUnauthorized, "Token inválido")
 			c.Abort()
+			return
+		}
+
+		// Guardar información del usuario en el contexto
+		c.Set("userID", uint(sub))
+		c.Set("userRol", claims["rol"])
+		c.Next()
+	} else {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Token inválido")
+		c.Abort()
+	}
+}
+
+// AuthStream acepta además un ticket del stream en ?ticket=, ya que EventSource del navegador no
+// permite enviar encabezados. El token de sesión nunca va en la URL: quedaría en los logs.
+func AuthStream() gin.HandlerFunc {
+	autenticarSesion := AuthMiddleware()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" && c.Query("ticket") != "" {
+			autenticar(c, c.Query("ticket"), clave.UsoStream)
+			return
 		}
+		autenticarSesion(c)
 	}
 }
 
@@ -1,16 +1,195 @@
 package migrate
 
 import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
 	"github.com/Ilimm9/CMedicas/initializers"
 	"github.com/Ilimm9/CMedicas/models"
+	"gorm.io/gorm"
 )
 
 func Migrations(){
 	initializers.DB.AutoMigrate(&models.Persona{})
 	initializers.DB.AutoMigrate(&models.Usuario{})
 	initializers.DB.AutoMigrate(&models.Medico{})
+	initializers.DB.AutoMigrate(&models.TipoCita{})
+	initializers.DB.AutoMigrate(&models.SerieCita{})
 	initializers.DB.AutoMigrate(&models.Cita{})
 	initializers.DB.AutoMigrate(&models.Horario{})
+	// Las notificaciones anteriores a la entrega por canales no se envían
+	notificacionesPrevias := initializers.DB.Migrator().HasTable(&models.Notificacion{}) &&
+		!initializers.DB.Migrator().HasColumn(&models.Notificacion{}, "DespachadaEn")
 	initializers.DB.AutoMigrate(&models.Notificacion{})
+	if notificacionesPrevias {
+		initializers.DB.Exec(`UPDATE notificacions SET despachada_en = NOW() WHERE despachada_en IS NULL`)
+	}
 	initializers.DB.AutoMigrate(&models.Observacion{})
-}
\ No newline at end of file
+	initializers.DB.AutoMigrate(&models.ConfiguracionEspecialidad{})
+	initializers.DB.AutoMigrate(&models.TipoCitaEspecialidad{})
+	initializers.DB.AutoMigrate(&models.ListaEspera{})
+	initializers.DB.AutoMigrate(&models.ReprogramacionCita{})
+	initializers.DB.AutoMigrate(&models.CambioEstadoCita{})
+	initializers.DB.AutoMigrate(&models.BloqueoAgenda{})
+	initializers.DB.AutoMigrate(&models.DiaFestivo{})
+	initializers.DB.AutoMigrate(&models.PoliticaCancelacion{})
+	initializers.DB.AutoMigrate(&models.SalaVirtual{})
+	initializers.DB.AutoMigrate(&models.CalendarioToken{})
+	initializers.DB.AutoMigrate(&models.RecordatorioCita{})
+	initializers.DB.AutoMigrate(&models.AvisoSinEspacio{})
+	initializers.DB.AutoMigrate(&models.EntregaNotificacion{})
+	initializers.DB.AutoMigrate(&models.CanalUsuario{})
+	// Las notificaciones y entregas que quedaron pendientes antes del outbox se encolan en él
+	outboxNuevo := !initializers.DB.Migrator().HasTable(&models.EventoOutbox{})
+	initializers.DB.AutoMigrate(&models.EventoOutbox{})
+	if outboxNuevo {
+		initializers.DB.Exec(`INSERT INTO evento_outboxes (tipo, referencia, datos, estado, intentos, proximo_intento, creado_en)
+			SELECT ?, id, json_build_object('notificacion_id', id, 'usuario_id', id_usuario, 'cita_id', cita_id, 'tipo', tipo,
+				'mensaje', mensaje, 'fecha_envio', fecha_envio),
+				?, 0, NOW(), NOW()
+			FROM notificacions WHERE despachada_en IS NULL`, models.EventoNotificacionCreada, models.OutboxPendiente)
+		initializers.DB.Exec(`INSERT INTO evento_outboxes (tipo, referencia, datos, estado, intentos, proximo_intento, creado_en)
+			SELECT ?, id, json_build_object('entrega_id', id, 'notificacion_id', notificacion_id, 'canal', canal),
+				?, intentos, proximo_intento, NOW()
+			FROM entrega_notificacions WHERE estado = ?`, models.EventoEntregaEnviar, models.OutboxPendiente, models.EntregaPendiente)
+	}
+
+	// Citas creadas antes de registrar la duración
+	initializers.DB.Exec(`UPDATE cita SET fecha_fin = fecha_cita + duracion_minutos * INTERVAL '1 minute'
+		WHERE fecha_fin IS NULL OR fecha_fin = '0001-01-01'`)
+
+	initializers.DB.Exec(`DROP INDEX IF EXISTS idx_citas_medico_fecha_programada`)
+	restriccionTraslapeCitas()
+
+	// Catálogo inicial de tipos de cita
+	tiposIniciales := []models.TipoCita{
+		{Nombre: "primera vez", DuracionMinutos: 60, Activo: true},
+		{Nombre: "seguimiento", DuracionMinutos: 20, Activo: true},
+		{Nombre: "urgencia", DuracionMinutos: 30, Activo: true},
+	}
+	for _, tipo := range tiposIniciales {
+		initializers.DB.Where(models.TipoCita{Nombre: tipo.Nombre}).FirstOrCreate(&tipo)
+	}
+}
+
+// restriccionTraslapeCitas evita que dos citas activas del mismo médico se traslapen, aun cuando
+// dos peticiones concurrentes pasen la validación en Go. Los estados incluidos se guardan como
+// comentario de la restricción para recrearla cuando cambie models.EstadosCitaActivos.
+// Antes de crearla se cancelan las citas traslapadas de bases de datos anteriores. Si aun así no
+// se puede crear, la aplicación arranca sin ella y lo reporta: la agenda queda protegida solo por
+// la validación en Go.
+func restriccionTraslapeCitas() {
+	if err := initializers.DB.Exec(`CREATE EXTENSION IF NOT EXISTS btree_gist`).Error; err != nil {
+		log.Println("No se pudo crear la extensión btree_gist, las citas no tendrán restricción de traslape: ", err)
+		return
+	}
+
+	estados := strings.Join(models.EstadosCitaActivos, ",")
+
+	var actual string
+	if err := initializers.DB.Raw(`SELECT COALESCE(obj_description(oid, 'pg_constraint'), '')
+		FROM pg_constraint WHERE conname = 'citas_sin_traslape'`).Scan(&actual).Error; err != nil {
+		log.Println("No se pudo consultar la restricción de traslape de citas: ", err)
+		return
+	}
+	if actual == estados {
+		return
+	}
+
+	// Las sentencias DDL no admiten parámetros; los estados son constantes del código
+	lista := "'" + strings.Join(models.EstadosCitaActivos, "', '") + "'"
+
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`ALTER TABLE cita DROP CONSTRAINT IF EXISTS citas_sin_traslape`).Error; err != nil {
+			return err
+		}
+		if err := cancelarCitasTraslapadas(tx, lista); err != nil {
+			return err
+		}
+		if err := tx.Exec(`ALTER TABLE cita ADD CONSTRAINT citas_sin_traslape EXCLUDE USING gist
+			(medico_id WITH =, tstzrange(fecha_cita, fecha_fin) WITH &&) WHERE (estado IN (` + lista + `))`).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`COMMENT ON CONSTRAINT citas_sin_traslape ON cita IS '` + estados + `'`).Error
+	})
+	if err != nil {
+		log.Println("No se pudo crear la restricción de traslape de citas: ", err)
+	}
+}
+
+// cancelarCitasTraslapadas cancela la cita más reciente de cada par de citas activas traslapadas
+// (p. ej. citas anteriores a la duración, que tomaron 30 minutos por defecto) y deja el cambio en
+// su historial. Se resuelve un par a la vez, en orden de ID, para no cancelar más de lo necesario.
+func cancelarCitasTraslapadas(tx *gorm.DB, lista string) error {
+	for {
+		var traslape struct {
+			MedicoID uint
+			CitaA    uint
+			CitaB    uint
+		}
+		if err := tx.Raw(`SELECT a.medico_id, a.id AS cita_a, b.id AS cita_b
+			FROM cita a JOIN cita b ON b.medico_id = a.medico_id AND b.id > a.id
+				AND tstzrange(a.fecha_cita, a.fecha_fin) && tstzrange(b.fecha_cita, b.fecha_fin)
+			WHERE a.estado IN (` + lista + `) AND b.estado IN (` + lista + `)
+			ORDER BY b.id, a.id
+			LIMIT 1`).Scan(&traslape).Error; err != nil {
+			return err
+		}
+		if traslape.CitaB == 0 {
+			return nil
+		}
+
+		var cita models.Cita
+		if err := tx.Select("id", "paciente_id", "medico_id", "fecha_cita", "estado").First(&cita, traslape.CitaB).Error; err != nil {
+			return err
+		}
+
+		motivo := fmt.Sprintf("Se traslapaba con la cita %d del mismo médico", traslape.CitaA)
+		if err := tx.Exec(`UPDATE cita SET estado = ?, motivo_cancelacion = ? WHERE id = ?`,
+			models.EstadoCancelada, motivo, cita.ID).Error; err != nil {
+			return err
+		}
+
+		cambio := models.CambioEstadoCita{
+			CitaID:         cita.ID,
+			EstadoAnterior: cita.Estado,
+			EstadoNuevo:    models.EstadoCancelada,
+			Rol:            models.RolSistema,
+			Motivo:         motivo,
+		}
+		if err := tx.Create(&cambio).Error; err != nil {
+			return err
+		}
+		if err := models.RegistrarEvento(tx, models.EventoCitaEstado, cita.ID, map[string]interface{}{
+			"cita_id":         cita.ID,
+			"paciente_id":     cita.PacienteID,
+			"medico_id":       cita.MedicoID,
+			"fecha_cita":      cita.FechaCita,
+			"estado_anterior": cita.Estado,
+			"estado_nuevo":    models.EstadoCancelada,
+			"rol":             models.RolSistema,
+			"motivo":          motivo,
+		}); err != nil {
+			return err
+		}
+
+		// Avisar al paciente si la cita aún no ocurre
+		if cita.FechaCita.After(time.Now()) {
+			notificacion := models.Notificacion{
+				IDUsuario: cita.PacienteID,
+				CitaID:    cita.ID,
+				Tipo:      "cancelación",
+				Mensaje: "Su cita del " + cita.FechaCita.In(time.Local).Format("02/01/2006 15:04") +
+					" fue cancelada porque se traslapaba con otra cita del médico. Comuníquese con la clínica para reagendarla",
+				FechaEnvio: time.Now(),
+			}
+			if err := tx.Create(&notificacion).Error; err != nil {
+				return err
+			}
+		}
+
+		log.Printf("Cita %d del médico %d cancelada: se traslapaba con la cita %d", cita.ID, traslape.MedicoID, traslape.CitaA)
+	}
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Aviso al paciente de que su cita no se pudo mover a otro médico al reasignar una ausencia. La
+// restricción única evita repetirlo si la reasignación se vuelve a ejecutar; incluye la fecha de la
+// cita para volver a avisar si se reprograma y tampoco hay espacio en la nueva fecha.
+type AvisoSinEspacio struct {
+    ID             uint          `gorm:"primaryKey"`
+    CitaID         uint          `gorm:"not null;uniqueIndex:idx_aviso_sin_espacio"`
+    Cita           Cita          `gorm:"foreignKey:CitaID;constraint:OnDelete:CASCADE;"`
+    FechaCita      time.Time     `gorm:"not null;uniqueIndex:idx_aviso_sin_espacio"`
+    NotificacionID *uint
+    Notificacion   *Notificacion `gorm:"foreignKey:NotificacionID;constraint:OnDelete:SET NULL;"`
+    CreadoEn       time.Time     `gorm:"autoCreateTime"`
+}
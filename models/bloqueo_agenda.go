@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Ausencia o excepción en la agenda de un médico (vacaciones, salida temprana, etc.)
+// Para bloqueos de día completo Inicio y Fin van de medianoche a medianoche.
+type BloqueoAgenda struct {
+    ID          uint      `gorm:"primaryKey"`
+    MedicoID    uint      `gorm:"not null;index"`
+    Medico      Medico    `gorm:"foreignKey:MedicoID;constraint:OnDelete:CASCADE;"`
+    Inicio      time.Time `gorm:"not null;index"`
+    Fin         time.Time `gorm:"not null;index"`
+    DiaCompleto bool      `gorm:"not null;default:false"`
+    Motivo      string    `gorm:"type:text;not null"`
+    UsuarioID   uint      `gorm:"not null"` // Quien registró el bloqueo
+    CreadoEn    time.Time `gorm:"autoCreateTime"`
+}
+
+// Traslapa indica si el bloqueo se cruza con el intervalo [inicio, fin)
+func (b BloqueoAgenda) Traslapa(inicio, fin time.Time) bool {
+    return b.Inicio.Before(fin) && b.Fin.After(inicio)
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Token secreto con el que un usuario suscribe su calendario externo al feed iCalendar de sus citas
+type CalendarioToken struct {
+    ID        uint      `gorm:"primaryKey"`
+    UsuarioID uint      `gorm:"not null;uniqueIndex"`
+    Usuario   Usuario   `gorm:"foreignKey:UsuarioID;constraint:OnDelete:CASCADE;"`
+    Token     string    `gorm:"size:64;not null;uniqueIndex"`
+    CreadoEn  time.Time `gorm:"autoCreateTime"`
+}
@@ -0,0 +1,11 @@
+package models
+
+// Preferencia de un usuario sobre un canal de entrega de notificaciones. Sin registro se usa
+// el valor por defecto del canal.
+type CanalUsuario struct {
+    ID        uint    `gorm:"primaryKey"`
+    UsuarioID uint    `gorm:"not null;uniqueIndex:idx_canal_usuario"`
+    Usuario   Usuario `gorm:"foreignKey:UsuarioID;constraint:OnDelete:CASCADE;"`
+    Canal     string  `gorm:"type:varchar(20);not null;uniqueIndex:idx_canal_usuario"`
+    Activo    bool    `gorm:"not null"`
+}
@@ -1,17 +1,50 @@
 package models
 
-import "time"
+import (
+    "time"
+
+    "gorm.io/gorm"
+)
 
 type Cita struct {
-    ID         uint      `gorm:"primaryKey"`
-    PacienteID uint      `gorm:"not null"`
-    Paciente   Usuario   `gorm:"foreignKey:PacienteID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
-    MedicoID   uint      `gorm:"not null"`
-    Medico     Medico    `gorm:"foreignKey:MedicoID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
-    FechaCita  time.Time `gorm:"not null;index"` // Índice para búsquedas
-    Motivo     string    `gorm:"type:text"`
-    Estado     string    `gorm:"type:varchar(20);check(estado IN ('programada', 'cancelada', 'completada'));index"`
-    CreadaEn   time.Time `gorm:"autoCreateTime"`
+    ID              uint      `gorm:"primaryKey"`
+    PacienteID      uint      `gorm:"not null"`
+    Paciente        Usuario   `gorm:"foreignKey:PacienteID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+    MedicoID        uint      `gorm:"not null"`
+    Medico          Medico    `gorm:"foreignKey:MedicoID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+    FechaCita       time.Time `gorm:"not null;index"` // Índice para búsquedas
+    DuracionMinutos int       `gorm:"not null;default:30"`
+    FechaFin        time.Time `gorm:"index"` // Calculada a partir de FechaCita y DuracionMinutos
+    TipoCitaID      *uint     `gorm:"index"`
+    TipoCita        *TipoCita `gorm:"foreignKey:TipoCitaID"`
+    Precio          float64   `gorm:"type:numeric(10,2);not null;default:0"`
+    SerieID         *uint     `gorm:"index"` // Serie recurrente a la que pertenece, si aplica
+    Motivo          string    `gorm:"type:text"`
+    Modalidad       string    `gorm:"type:varchar(20);not null;default:'presencial';check(modalidad IN ('presencial', 'videollamada'))"`
+    Estado          string    `gorm:"type:varchar(20);check(estado IN ('reservada', 'por_aprobar', 'programada', 'confirmada', 'en_sala', 'en_consulta', 'completada', 'cancelada', 'no_asistio'));index"`
+    LlegadaEn       *time.Time // Hora en que el paciente se registró en recepción
+    MotivoCancelacion     string `gorm:"type:text"`
+    CancelacionPenalizada bool   `gorm:"not null;default:false"` // Cancelada por el paciente fuera del aviso mínimo
+    CreadaEn        time.Time `gorm:"autoCreateTime"`
     
     Notificaciones []Notificacion `gorm:"foreignKey:CitaID"`
+    Reprogramaciones []ReprogramacionCita `gorm:"foreignKey:CitaID;constraint:OnDelete:CASCADE;"`
+    CambiosEstado    []CambioEstadoCita   `gorm:"foreignKey:CitaID;constraint:OnDelete:CASCADE;"`
+}
+
+// Modalidades de atención
+const (
+    ModalidadPresencial   = "presencial"
+    ModalidadVideollamada = "videollamada"
+)
+
+// Duracion devuelve la duración de la cita
+func (c Cita) Duracion() time.Duration {
+    return time.Duration(c.DuracionMinutos) * time.Minute
+}
+
+// BeforeSave mantiene FechaFin sincronizada con la fecha y la duración
+func (c *Cita) BeforeSave(tx *gorm.DB) error {
+    c.FechaFin = c.FechaCita.Add(c.Duracion())
+    return nil
 }
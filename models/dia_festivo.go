@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// Día en que la clínica permanece cerrada (feriado nacional o evento interno)
+type DiaFestivo struct {
+    ID       uint      `gorm:"primaryKey"`
+    Fecha    time.Time `gorm:"type:date;uniqueIndex;not null"`
+    Nombre   string    `gorm:"size:100;not null"`
+    CreadoEn time.Time `gorm:"autoCreateTime"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Estados de la entrega de una notificación por un canal
+const (
+    EntregaPendiente = "pendiente"
+    EntregaEnviada   = "enviada"
+    EntregaFallida   = "fallida"
+)
+
+// Entrega de una notificación por un canal externo (correo, SMS, WhatsApp, webhook) con sus intentos
+type EntregaNotificacion struct {
+    ID             uint         `gorm:"primaryKey"`
+    NotificacionID uint         `gorm:"not null;uniqueIndex:idx_entrega_canal"`
+    Notificacion   Notificacion `gorm:"foreignKey:NotificacionID;constraint:OnDelete:CASCADE;"`
+    Canal          string       `gorm:"type:varchar(20);not null;uniqueIndex:idx_entrega_canal"`
+    Destino        string       `gorm:"size:255;not null"`
+    Estado         string       `gorm:"type:varchar(20);not null;default:'pendiente';index;check(estado IN ('pendiente', 'enviada', 'fallida'))"`
+    Intentos       int          `gorm:"not null;default:0"`
+    UltimoError    string       `gorm:"type:text"`
+    ProximoIntento time.Time    `gorm:"not null;index"`
+    EnviadaEn      *time.Time
+    CreadaEn       time.Time    `gorm:"autoCreateTime"`
+}
@@ -0,0 +1,8 @@
+package models
+
+// Configuración de agenda por especialidad (el nombre coincide con Medico.Especialidad)
+type ConfiguracionEspecialidad struct {
+    ID                  uint   `gorm:"primaryKey"`
+    Especialidad        string `gorm:"size:100;uniqueIndex;not null"`
+    DuracionCitaMinutos int    `gorm:"not null"`
+}
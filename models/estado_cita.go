@@ -0,0 +1,117 @@
+package models
+
+import (
+    "errors"
+    "time"
+)
+
+// Estados de una cita
+const (
+    EstadoReservada  = "reservada"   // Espacio apartado para un paciente de la lista de espera
+    EstadoPorAprobar = "por_aprobar" // Requiere aprobación de un administrador antes de programarse
+    EstadoProgramada = "programada"
+    EstadoConfirmada = "confirmada"
+    EstadoEnSala     = "en_sala"
+    EstadoEnConsulta = "en_consulta"
+    EstadoCompletada = "completada"
+    EstadoCancelada  = "cancelada"
+    EstadoNoAsistio  = "no_asistio"
+)
+
+// RolSistema identifica los cambios hechos por procesos internos (expiraciones, tareas programadas)
+const RolSistema = "sistema"
+
+var (
+    ErrTransicionInvalida    = errors.New("Transición de estado no válida")
+    ErrTransicionNoPermitida = errors.New("Tu rol no puede realizar este cambio de estado")
+)
+
+// transicionesCita define, para cada estado, a qué estados puede pasar y qué roles pueden hacerlo.
+// El rol sistema puede realizar cualquier transición válida. El médico puede iniciar la consulta sin
+// que recepción registre la llegada, como en las videollamadas o en clínicas sin recepción.
+var transicionesCita = map[string]map[string][]string{
+    EstadoReservada: {
+        EstadoProgramada: {"paciente", "administrador"},
+        EstadoCancelada:  {"administrador"},
+    },
+    EstadoPorAprobar: {
+        EstadoProgramada: {"administrador"},
+        EstadoCancelada:  {"paciente", "administrador"},
+    },
+    EstadoProgramada: {
+        EstadoConfirmada: {"paciente", "administrador"},
+        EstadoEnSala:     {"recepcionista", "administrador"},
+        EstadoEnConsulta: {"medico", "administrador"},
+        EstadoCancelada:  {"paciente", "medico", "administrador"},
+        EstadoNoAsistio:  {"medico", "administrador"},
+    },
+    EstadoConfirmada: {
+        EstadoEnSala:     {"recepcionista", "administrador"},
+        EstadoEnConsulta: {"medico", "administrador"},
+        EstadoCancelada:  {"paciente", "medico", "administrador"},
+        EstadoNoAsistio:  {"medico", "administrador"},
+    },
+    EstadoEnSala: {
+        EstadoEnConsulta: {"medico", "administrador"},
+        EstadoNoAsistio:  {"medico", "administrador"},
+    },
+    EstadoEnConsulta: {
+        EstadoCompletada: {"medico", "administrador"},
+    },
+}
+
+// Estados de cita que ocupan la agenda del médico
+var EstadosCitaActivos = []string{EstadoReservada, EstadoPorAprobar, EstadoProgramada, EstadoConfirmada, EstadoEnSala, EstadoEnConsulta}
+
+// Estados de una cita que aún no ha comenzado y puede cancelarse o reprogramarse
+var EstadosCitaPendientes = []string{EstadoProgramada, EstadoConfirmada}
+
+// EstadoActivo indica si la cita ocupa su espacio en la agenda
+func EstadoActivo(estado string) bool {
+    for _, activo := range EstadosCitaActivos {
+        if estado == activo {
+            return true
+        }
+    }
+    return false
+}
+
+// EstadoPendiente indica si la cita aún no ha comenzado
+func EstadoPendiente(estado string) bool {
+    return estado == EstadoProgramada || estado == EstadoConfirmada
+}
+
+// TransicionValida indica si existe la transición entre dos estados
+func TransicionValida(desde, hacia string) bool {
+    _, ok := transicionesCita[desde][hacia]
+    return ok
+}
+
+// ValidarTransicion verifica que la transición exista y que el rol pueda realizarla
+func ValidarTransicion(desde, hacia, rol string) error {
+    roles, ok := transicionesCita[desde][hacia]
+    if !ok {
+        return ErrTransicionInvalida
+    }
+    if rol == RolSistema {
+        return nil
+    }
+    for _, r := range roles {
+        if r == rol {
+            return nil
+        }
+    }
+    return ErrTransicionNoPermitida
+}
+
+// Registro de cada cambio de estado de una cita
+type CambioEstadoCita struct {
+    ID             uint      `gorm:"primaryKey"`
+    CitaID         uint      `gorm:"not null;index"`
+    EstadoAnterior string    `gorm:"type:varchar(20);not null"`
+    EstadoNuevo    string    `gorm:"type:varchar(20);not null;index"`
+    UsuarioID      *uint     // Nulo si el cambio lo hizo el sistema
+    Rol            string    `gorm:"type:varchar(20)"`
+    Motivo         string    `gorm:"type:text"`
+    CreadoEn       time.Time `gorm:"autoCreateTime;index"`
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+    "encoding/json"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// Tipos de evento del outbox
+const (
+    EventoNotificacionCreada = "notificacion.creada" // Referencia: ID de la notificación
+    EventoEntregaEnviar      = "entrega.enviar"      // Referencia: ID de la entrega
+    EventoCitaEstado         = "cita.estado"         // Referencia: ID de la cita
+    EventoCitaReprogramada   = "cita.reprogramada"   // Referencia: ID de la cita
+)
+
+// Canal de Postgres por el que se avisa de cada evento nuevo a todas las instancias
+const CanalEventos = "cmedicas_eventos"
+
+// Estados de un evento del outbox
+const (
+    OutboxPendiente = "pendiente"
+    OutboxProcesado = "procesado"
+    OutboxFallido   = "fallido" // Agotó sus intentos; solo se reintenta a mano
+)
+
+// Evento pendiente de procesar fuera de la transacción que lo originó. Se guarda en la misma
+// transacción que el cambio, así que no se pierde si el proceso cae antes de atenderlo.
+type EventoOutbox struct {
+    ID             uint       `gorm:"primaryKey"`
+    Tipo           string     `gorm:"type:varchar(40);not null;index"`
+    Referencia     uint       `gorm:"not null;index"`
+    Datos          string     `gorm:"type:jsonb;not null"`
+    Estado         string     `gorm:"type:varchar(20);not null;default:'pendiente';index;check(estado IN ('pendiente', 'procesado', 'fallido'))"`
+    Intentos       int        `gorm:"not null;default:0"`
+    UltimoError    string     `gorm:"type:text"`
+    ProximoIntento time.Time  `gorm:"not null;index"`
+    ProcesadoEn    *time.Time
+    CreadoEn       time.Time  `gorm:"autoCreateTime"`
+}
+
+// RegistrarEvento agrega un evento al outbox dentro de la transacción actual y lo anuncia en
+// CanalEventos; Postgres entrega el aviso solo si la transacción se confirma
+func RegistrarEvento(tx *gorm.DB, tipo string, referencia uint, datos interface{}) error {
+    contenido, err := json.Marshal(datos)
+    if err != nil {
+        return err
+    }
+
+    evento := EventoOutbox{
+        Tipo:           tipo,
+        Referencia:     referencia,
+        Datos:          string(contenido),
+        Estado:         OutboxPendiente,
+        ProximoIntento: time.Now(),
+    }
+    if err := tx.Create(&evento).Error; err != nil {
+        return err
+    }
+
+    aviso, err := json.Marshal(map[string]interface{}{"id": evento.ID, "tipo": tipo})
+    if err != nil {
+        return err
+    }
+    return tx.Exec("SELECT pg_notify(?, ?)", CanalEventos, string(aviso)).Error
+}
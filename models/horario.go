@@ -10,3 +10,24 @@ type Horario struct {
     HoraInicio time.Time
     HoraFin    time.Time
 }
+
+// DiasSemana traduce el día de la semana de Go al nombre usado en Horario.DiaSemana
+var DiasSemana = map[time.Weekday]string{
+    time.Monday:    "Lunes",
+    time.Tuesday:   "Martes",
+    time.Wednesday: "Miércoles",
+    time.Thursday:  "Jueves",
+    time.Friday:    "Viernes",
+    time.Saturday:  "Sábado",
+    time.Sunday:    "Domingo",
+}
+
+// Rango devuelve el inicio y fin del horario aplicados a la fecha indicada. Se toman la hora y los
+// minutos tal como se guardaron: convertir la fecha del año 0 a otra zona aplicaría su desfase
+// histórico (LMT) y movería los minutos.
+func (h Horario) Rango(fecha time.Time) (time.Time, time.Time) {
+    loc := fecha.Location()
+    y, m, d := fecha.Date()
+    return time.Date(y, m, d, h.HoraInicio.Hour(), h.HoraInicio.Minute(), 0, 0, loc),
+        time.Date(y, m, d, h.HoraFin.Hour(), h.HoraFin.Minute(), 0, 0, loc)
+}
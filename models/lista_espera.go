@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Registro de un paciente interesado en un médico (o especialidad) dentro de un rango de fechas
+type ListaEspera struct {
+    ID           uint      `gorm:"primaryKey"`
+    PacienteID   uint      `gorm:"not null;index"`
+    Paciente     Usuario   `gorm:"foreignKey:PacienteID"`
+    MedicoID     *uint     `gorm:"index"` // Si es nulo aplica a cualquier médico de la especialidad
+    Medico       *Medico   `gorm:"foreignKey:MedicoID"`
+    Especialidad string    `gorm:"size:100"`
+    Desde        time.Time `gorm:"not null"`
+    Hasta        time.Time `gorm:"not null"`
+    Estado       string    `gorm:"type:varchar(20);not null;default:'activa';check(estado IN ('activa', 'ofertada', 'asignada', 'expirada', 'cancelada'));index"`
+    CreadaEn     time.Time `gorm:"autoCreateTime"`
+
+    // Oferta vigente: cita reservada para el paciente hasta OfertaExpira
+    CitaOfertaID *uint
+    CitaOferta   *Cita      `gorm:"foreignKey:CitaOfertaID"`
+    OfertaExpira *time.Time `gorm:"index"`
+}
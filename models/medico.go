@@ -5,6 +5,7 @@ type Medico struct {
     UsuarioID    uint    `gorm:"unique;not null"`
     Usuario      Usuario `gorm:"foreignKey:UsuarioID"`
     Especialidad string  `gorm:"size:100;not null"`
+    DuracionCitaMinutos *int // Si es nulo se usa la configuración de la especialidad
     Horarios    []Horario `gorm:"foreignKey:MedicoID"`
     Cita       []Cita    `gorm:"foreignKey:MedicoID"` 
 }
\ No newline at end of file
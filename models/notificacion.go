@@ -1,14 +1,36 @@
 package models
 
-import "time"
+import (
+    "time"
+
+    "gorm.io/gorm"
+)
 
 type Notificacion struct {
     ID         uint      `gorm:"primaryKey"`
-    IDUsuario  uint      `gorm:"not null"`
+    IDUsuario  uint      `gorm:"not null;index"`
     Usuario    Usuario   `gorm:"foreignKey:IDUsuario"` // Relación con Usuario
     CitaID     uint      `gorm:"not null"`
     Cita       Cita      `gorm:"foreignKey:CitaID"` // Relación con Cita
-    Tipo       string    `gorm:"type:varchar(20);check(tipo IN ('confirmación', 'recordatorio', 'cancelación'))"`
+    Tipo       string    `gorm:"type:varchar(20);check(tipo IN ('confirmación', 'recordatorio', 'cancelación', 'oferta', 'reprogramación', 'aviso'))"`
     Mensaje    string    `gorm:"type:text"`
     FechaEnvio time.Time `gorm:"not null"`
-}
\ No newline at end of file
+    ConSala      bool       `gorm:"not null;default:false"` // Al entregarla por los canales del destinatario se agrega el enlace de videollamada
+    DespachadaEn *time.Time `gorm:"index"` // Cuando se programó su entrega por los canales del usuario
+    LeidaEn      *time.Time `gorm:"index"` // Nulo mientras el usuario no la lea
+    ArchivadaEn  *time.Time `gorm:"index"` // Las archivadas no aparecen en la bandeja por defecto
+    Entregas   []EntregaNotificacion `gorm:"foreignKey:NotificacionID"`
+}
+
+// AfterCreate registra la notificación en el outbox en la misma transacción, para que su entrega
+// por los canales externos no se pierda ni dependa de quién la creó
+func (n *Notificacion) AfterCreate(tx *gorm.DB) error {
+    return RegistrarEvento(tx, EventoNotificacionCreada, n.ID, map[string]interface{}{
+        "notificacion_id": n.ID,
+        "usuario_id":      n.IDUsuario,
+        "cita_id":         n.CitaID,
+        "tipo":            n.Tipo,
+        "mensaje":         n.Mensaje,
+        "fecha_envio":     n.FechaEnvio,
+    })
+}
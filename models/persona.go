@@ -13,3 +13,8 @@ type Persona struct {
     Direccion       string    `gorm:"type:text"`
     
 }
+
+// NombreCompleto devuelve el nombre con ambos apellidos
+func (p Persona) NombreCompleto() string {
+    return p.Nombre + " " + p.ApellidoPaterno + " " + p.ApellidoMaterno
+}
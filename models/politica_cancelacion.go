@@ -0,0 +1,54 @@
+package models
+
+import (
+    "time"
+)
+
+// Niveles a los que se puede configurar una política de cancelación; la más específica gana
+const (
+    PoliticaClinica      = "clinica"
+    PoliticaEspecialidad = "especialidad"
+    PoliticaMedico       = "medico"
+)
+
+// Reglas de cancelación de citas para toda la clínica, una especialidad o un médico
+type PoliticaCancelacion struct {
+    ID                uint      `gorm:"primaryKey"`
+    Nivel             string    `gorm:"type:varchar(20);not null;check(nivel IN ('clinica', 'especialidad', 'medico'))"`
+    Especialidad      string    `gorm:"size:100;index"` // Solo para nivel especialidad
+    MedicoID          *uint     `gorm:"index"`          // Solo para nivel medico
+    Medico            *Medico   `gorm:"foreignKey:MedicoID;constraint:OnDelete:CASCADE;"`
+    AnticipacionHoras int       `gorm:"not null;default:24"` // Aviso mínimo para que el paciente cancele sin penalización
+    PermitePaciente   bool      `gorm:"not null;default:true"`
+    PermiteMedico     bool      `gorm:"not null;default:true"`
+    PermiteTardia     bool      `gorm:"not null;default:false"` // Cancelaciones tardías permitidas pero penalizadas
+    ActualizadaEn     time.Time `gorm:"autoUpdateTime"`
+}
+
+// PoliticaCancelacionPorDefecto se aplica cuando no hay ninguna política configurada
+func PoliticaCancelacionPorDefecto() PoliticaCancelacion {
+    return PoliticaCancelacion{
+        Nivel:             PoliticaClinica,
+        AnticipacionHoras: 24,
+        PermitePaciente:   true,
+        PermiteMedico:     true,
+    }
+}
+
+// Anticipacion devuelve el aviso mínimo requerido
+func (p PoliticaCancelacion) Anticipacion() time.Duration {
+    return time.Duration(p.AnticipacionHoras) * time.Hour
+}
+
+// PermiteRol indica si el rol puede cancelar citas; el administrador siempre puede
+func (p PoliticaCancelacion) PermiteRol(rol string) bool {
+    switch rol {
+    case "administrador":
+        return true
+    case "paciente":
+        return p.PermitePaciente
+    case "medico":
+        return p.PermiteMedico
+    }
+    return false
+}
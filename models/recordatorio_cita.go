@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Registro de cada recordatorio programado de una cita. La restricción única evita duplicados
+// entre reinicios y réplicas; incluye la fecha de la cita para que al reprogramarla se vuelvan a enviar.
+// Un recordatorio fallido también se registra, con su error, para que no bloquee a las demás citas.
+type RecordatorioCita struct {
+    ID                  uint          `gorm:"primaryKey"`
+    CitaID              uint          `gorm:"not null;uniqueIndex:idx_recordatorio_cita"`
+    Cita                Cita          `gorm:"foreignKey:CitaID;constraint:OnDelete:CASCADE;"`
+    FechaCita           time.Time     `gorm:"not null;uniqueIndex:idx_recordatorio_cita"`
+    AnticipacionMinutos int           `gorm:"not null;uniqueIndex:idx_recordatorio_cita"`
+    NotificacionID      *uint         // Nulo si se omitió porque ya tocaba uno más cercano a la cita o si falló
+    Notificacion        *Notificacion `gorm:"foreignKey:NotificacionID;constraint:OnDelete:SET NULL;"`
+    Error               string        `gorm:"type:text"` // Por qué no se pudo crear la notificación; no se reintenta
+    CreadoEn            time.Time     `gorm:"autoCreateTime"`
+}
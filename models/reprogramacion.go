@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Historial de cambios de fecha de una cita
+type ReprogramacionCita struct {
+    ID            uint      `gorm:"primaryKey"`
+    CitaID        uint      `gorm:"not null;index"`
+    FechaAnterior time.Time `gorm:"not null"`
+    FechaNueva    time.Time `gorm:"not null"`
+    UsuarioID     uint      `gorm:"not null"` // Quién reprogramó
+    Usuario       Usuario   `gorm:"foreignKey:UsuarioID"`
+    Motivo        string    `gorm:"type:text"`
+    CreadoEn      time.Time `gorm:"autoCreateTime"`
+}
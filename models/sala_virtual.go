@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Enlace de videollamada de una cita. Se guarda aparte de la cita para que solo se entregue al
+// paciente y al médico de la cita.
+type SalaVirtual struct {
+    ID       uint      `gorm:"primaryKey"`
+    CitaID   uint      `gorm:"not null;uniqueIndex"`
+    Cita     Cita      `gorm:"foreignKey:CitaID;constraint:OnDelete:CASCADE;"`
+    URL      string    `gorm:"size:255;not null"`
+    CreadaEn time.Time `gorm:"autoCreateTime"`
+}
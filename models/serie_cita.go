@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Serie de citas recurrentes creada a partir de una regla de recurrencia
+type SerieCita struct {
+    ID           uint      `gorm:"primaryKey"`
+    PacienteID   uint      `gorm:"not null;index"`
+    MedicoID     uint      `gorm:"not null;index"`
+    Frecuencia   string    `gorm:"type:varchar(20);not null;check(frecuencia IN ('semanal', 'quincenal'))"`
+    Repeticiones int       // Número de citas solicitadas (si se usó conteo)
+    Hasta        *time.Time // Fecha límite (si se usó fecha)
+    CreadaEn     time.Time `gorm:"autoCreateTime"`
+
+    Citas []Cita `gorm:"foreignKey:SerieID"`
+}
+
+// DiasIntervalo devuelve los días entre dos citas consecutivas de la serie
+func (s SerieCita) DiasIntervalo() int {
+    if s.Frecuencia == "quincenal" {
+        return 14
+    }
+    return 7
+}
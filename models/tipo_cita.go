@@ -0,0 +1,32 @@
+package models
+
+// Catálogo de tipos de cita (primera vez, seguimiento, urgencia...)
+type TipoCita struct {
+    ID              uint    `gorm:"primaryKey"`
+    Nombre          string  `gorm:"size:100;uniqueIndex;not null"`
+    DuracionMinutos int     `gorm:"not null"`
+    Precio          float64 `gorm:"type:numeric(10,2);not null;default:0"`
+    Activo          bool    `gorm:"not null;default:true"`
+
+    // Especialidades que ofrecen este tipo; si está vacío lo ofrecen todas
+    Especialidades []TipoCitaEspecialidad `gorm:"foreignKey:TipoCitaID;constraint:OnDelete:CASCADE;"`
+}
+
+type TipoCitaEspecialidad struct {
+    ID           uint   `gorm:"primaryKey"`
+    TipoCitaID   uint   `gorm:"not null;uniqueIndex:idx_tipo_especialidad"`
+    Especialidad string `gorm:"size:100;not null;uniqueIndex:idx_tipo_especialidad"`
+}
+
+// OfrecidoPor indica si la especialidad puede agendar este tipo de cita
+func (t TipoCita) OfrecidoPor(especialidad string) bool {
+    if len(t.Especialidades) == 0 {
+        return true
+    }
+    for _, e := range t.Especialidades {
+        if e.Especialidad == especialidad {
+            return true
+        }
+    }
+    return false
+}
@@ -6,10 +6,11 @@ type Usuario struct {
     ID         uint      `gorm:"primaryKey"`
     PersonaID  uint      `gorm:"uniqueIndex;not null"`
     Persona    Persona   `gorm:"foreignKey:PersonaID"` // Referencia 
-    Rol        string    `gorm:"type:varchar(20);not null;check(rol IN ('paciente','medico','administrador'))"`
+    Rol        string    `gorm:"type:varchar(20);not null;check(rol IN ('paciente','medico','recepcionista','administrador'))"`
     Correo     string    `gorm:"size:100;unique;not null"`
-    Contrasena string    `gorm:"size:255;not null"`
+    Contrasena string    `gorm:"size:255;not null" json:"-"` // Hash; nunca se envía en las respuestas
     CreadoEn   time.Time `gorm:"autoCreateTime"`
+    Inasistencias int     `gorm:"not null;default:0"` // Citas a las que el paciente no asistió
     Medico      *Medico       `gorm:"foreignKey:UsuarioID"`
     Cita       []Cita        `gorm:"foreignKey:PacienteID"`
     Notificaciones []Notificacion `gorm:"foreignKey:IDUsuario"`
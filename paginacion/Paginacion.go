@@ -0,0 +1,263 @@
+package paginacion
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Límites de filas por página
+const (
+	LimitePorDefecto = 50
+	LimiteMaximo     = 200
+)
+
+// ErrorParametro indica un parámetro de consulta inválido; se responde como 400
+type ErrorParametro struct {
+	Mensaje string
+}
+
+func (e *ErrorParametro) Error() string {
+	return e.Mensaje
+}
+
+// Orden es un campo por el que se puede ordenar un listado. Valor extrae el dato de una fila para
+// armar el cursor de la siguiente página; la columna no debe admitir nulos.
+type Orden[T any] struct {
+	Columna string
+	Valor   func(T) any
+}
+
+// Listado describe lo que admite un endpoint de listado:
+//   - orden=campo u orden=-campo (descendente), entre los campos de Orden
+//   - filtros por igualdad: ?parametro=valor o ?parametro=a,b; los de FiltrosNumericos (IDs)
+//     solo admiten enteros no negativos
+//   - rangos numéricos con parametro_min y parametro_max sobre las columnas de Rangos
+//   - rango de fechas con desde/hasta sobre CampoFecha
+//   - búsqueda de texto con q sobre las columnas de Busqueda
+//   - pagina y limite, o cursor con el siguiente_cursor de la respuesta anterior
+type Listado[T any] struct {
+	Tabla            string
+	ID               func(T) uint
+	Orden            map[string]Orden[T]
+	OrdenPorDefecto  string
+	Filtros          map[string]string
+	FiltrosNumericos map[string]string
+	Rangos           map[string]string
+	CampoFecha       string
+	Busqueda         []string
+	Preload          []string
+}
+
+// Meta acompaña a los datos de un listado en la respuesta
+type Meta struct {
+	Total           int64  `json:"total"`
+	Limite          int    `json:"limite"`
+	Pagina          int    `json:"pagina,omitempty"` // Solo al paginar por número de página
+	TotalPaginas    int    `json:"total_paginas,omitempty"`
+	Orden           string `json:"orden"`
+	SiguienteCursor string `json:"siguiente_cursor,omitempty"` // Vacío en la última página
+}
+
+// cursor es la posición de la última fila entregada
+type cursor struct {
+	Orden string          `json:"o"`
+	Valor json.RawMessage `json:"v"`
+	ID    uint            `json:"id"`
+}
+
+// leerFecha acepta YYYY-MM-DD o RFC 3339; en el primer caso indica que es un día completo
+func leerFecha(valor string) (time.Time, bool, error) {
+	if fecha, err := time.ParseInLocation("2006-01-02", valor, time.Local); err == nil {
+		return fecha, true, nil
+	}
+	fecha, err := time.Parse(time.RFC3339, valor)
+	return fecha, false, err
+}
+
+// valoresFiltro devuelve los valores pedidos para un filtro: ?parametro=valor o ?parametro=a,b
+func valoresFiltro(c *gin.Context, parametro string) []string {
+	valor := c.Query(parametro)
+	if valor == "" {
+		return nil
+	}
+	return strings.Split(valor, ",")
+}
+
+// filtrar agrega la condición de igualdad de un filtro
+func filtrar(query *gorm.DB, columna string, valores []string) *gorm.DB {
+	if len(valores) > 1 {
+		return query.Where(columna+" IN ?", valores)
+	}
+	return query.Where(columna+" = ?", valores[0])
+}
+
+// Paginar aplica a la consulta los filtros, el orden y la página o cursor pedidos, cuenta el total
+// y carga las filas en destino. La consulta no debe traer Preload: se agregan después de contar.
+func Paginar[T any](c *gin.Context, query *gorm.DB, listado Listado[T], destino *[]T) (*Meta, error) {
+	query = query.Model(new(T))
+
+	for parametro, columna := range listado.Filtros {
+		if valores := valoresFiltro(c, parametro); len(valores) > 0 {
+			query = filtrar(query, columna, valores)
+		}
+	}
+
+	// Validar antes de consultar: Postgres rechazaría el valor con un error de conversión
+	for parametro, columna := range listado.FiltrosNumericos {
+		valores := valoresFiltro(c, parametro)
+		for _, valor := range valores {
+			if _, err := strconv.ParseUint(valor, 10, 64); err != nil {
+				return nil, &ErrorParametro{"El filtro '" + parametro + "' debe ser un número entero"}
+			}
+		}
+		if len(valores) > 0 {
+			query = filtrar(query, columna, valores)
+		}
+	}
+
+	for parametro, columna := range listado.Rangos {
+		for _, extremo := range [][2]string{{"_min", " >= ?"}, {"_max", " <= ?"}} {
+			valor := c.Query(parametro + extremo[0])
+			if valor == "" {
+				continue
+			}
+			n, err := strconv.Atoi(valor)
+			if err != nil {
+				return nil, &ErrorParametro{"El parámetro '" + parametro + extremo[0] + "' debe ser un número entero"}
+			}
+			query = query.Where(columna+extremo[1], n)
+		}
+	}
+
+	if listado.CampoFecha != "" {
+		if valor := c.Query("desde"); valor != "" {
+			desde, _, err := leerFecha(valor)
+			if err != nil {
+				return nil, &ErrorParametro{"Formato de fecha 'desde' inválido. Use YYYY-MM-DD o RFC 3339"}
+			}
+			query = query.Where(listado.CampoFecha+" >= ?", desde)
+		}
+		if valor := c.Query("hasta"); valor != "" {
+			hasta, diaCompleto, err := leerFecha(valor)
+			if err != nil {
+				return nil, &ErrorParametro{"Formato de fecha 'hasta' inválido. Use YYYY-MM-DD o RFC 3339"}
+			}
+			// Una fecha sin hora incluye todo el día
+			if diaCompleto {
+				query = query.Where(listado.CampoFecha+" < ?", hasta.AddDate(0, 0, 1))
+			} else {
+				query = query.Where(listado.CampoFecha+" <= ?", hasta)
+			}
+		}
+	}
+
+	if q := strings.TrimSpace(c.Query("q")); q != "" && len(listado.Busqueda) > 0 {
+		condiciones := make([]string, len(listado.Busqueda))
+		valores := make([]interface{}, len(listado.Busqueda))
+		for i, columna := range listado.Busqueda {
+			condiciones[i] = columna + " ILIKE ?"
+			valores[i] = "%" + q + "%"
+		}
+		query = query.Where("("+strings.Join(condiciones, " OR ")+")", valores...)
+	}
+
+	nombreOrden := c.DefaultQuery("orden", listado.OrdenPorDefecto)
+	descendente := strings.HasPrefix(nombreOrden, "-")
+	orden, ok := listado.Orden[strings.TrimPrefix(nombreOrden, "-")]
+	if !ok {
+		campos := make([]string, 0, len(listado.Orden))
+		for campo := range listado.Orden {
+			campos = append(campos, campo)
+		}
+		sort.Strings(campos)
+		return nil, &ErrorParametro{"Orden inválido. Campos permitidos: " + strings.Join(campos, ", ")}
+	}
+
+	limite := LimitePorDefecto
+	if valor := c.Query("limite"); valor != "" {
+		n, err := strconv.Atoi(valor)
+		if err != nil || n < 1 || n > LimiteMaximo {
+			return nil, &ErrorParametro{"El límite debe estar entre 1 y " + strconv.Itoa(LimiteMaximo)}
+		}
+		limite = n
+	}
+
+	meta := &Meta{Limite: limite, Orden: nombreOrden}
+
+	// El total no depende de la página ni del cursor
+	if err := query.Session(&gorm.Session{}).Count(&meta.Total).Error; err != nil {
+		return nil, err
+	}
+
+	columnaID := listado.Tabla + ".id"
+	direccion, comparacion := " ASC", " > "
+	if descendente {
+		direccion, comparacion = " DESC", " < "
+	}
+	query = query.Order(orden.Columna + direccion).Order(columnaID + direccion).Limit(limite)
+
+	if valor := c.Query("cursor"); valor != "" {
+		// El valor del cursor se decodifica al mismo tipo que devuelve el campo de orden
+		var actual cursor
+		datos, err := base64.RawURLEncoding.DecodeString(valor)
+		if err == nil {
+			err = json.Unmarshal(datos, &actual)
+		}
+		if err != nil || actual.Orden != nombreOrden {
+			return nil, &ErrorParametro{"Cursor inválido para este orden"}
+		}
+
+		var cero T
+		posicion := reflect.New(reflect.TypeOf(orden.Valor(cero)))
+		if err := json.Unmarshal(actual.Valor, posicion.Interface()); err != nil {
+			return nil, &ErrorParametro{"Cursor inválido para este orden"}
+		}
+
+		query = query.Where("("+orden.Columna+", "+columnaID+")"+comparacion+"(?, ?)", posicion.Elem().Interface(), actual.ID)
+	} else {
+		pagina := 1
+		if valor := c.Query("pagina"); valor != "" {
+			n, err := strconv.Atoi(valor)
+			if err != nil || n < 1 {
+				return nil, &ErrorParametro{"La página debe ser un número mayor a cero"}
+			}
+			pagina = n
+		}
+		meta.Pagina = pagina
+		meta.TotalPaginas = int(math.Ceil(float64(meta.Total) / float64(limite)))
+		query = query.Offset((pagina - 1) * limite)
+	}
+
+	for _, relacion := range listado.Preload {
+		query = query.Preload(relacion)
+	}
+
+	if err := query.Find(destino).Error; err != nil {
+		return nil, err
+	}
+
+	// Si la página vino llena puede haber más filas
+	if filas := *destino; len(filas) == limite {
+		ultima := filas[len(filas)-1]
+		valor, err := json.Marshal(orden.Valor(ultima))
+		if err != nil {
+			return nil, err
+		}
+		datos, err := json.Marshal(cursor{Orden: nombreOrden, Valor: valor, ID: listado.ID(ultima)})
+		if err != nil {
+			return nil, err
+		}
+		meta.SiguienteCursor = base64.RawURLEncoding.EncodeToString(datos)
+	}
+
+	return meta, nil
+}
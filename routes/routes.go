@@ -14,6 +14,9 @@ func AdminRutas(r *gin.Engine) {
 		// Autenticación
 		public.POST("/auth/registro", controllers.RegistroCompleto)
 		public.POST("/auth/login", controllers.Login)
+
+		// Feed iCalendar: el token secreto de la URL reemplaza al JWT
+		public.GET("/calendario/feed/:token", controllers.GetFeedCalendario)
 		
 		// public.GET("/medicos/disponibles", controllers.GetMedicosDisponibles)
 		// public.GET("/especialidades", controllers.GetEspecialidades)
@@ -26,6 +29,8 @@ func AdminRutas(r *gin.Engine) {
 	{
 		// Perfil de usuario
 		protected.GET("/usuario/actual", controllers.GetCurrentUser)
+		protected.GET("/usuario/actual/canales", controllers.GetCanalesUsuarioActual)
+		protected.PUT("/usuario/actual/canales", controllers.UpdateCanalesUsuarioActual)
 		// protected.PUT("/usuario/actual", controllers.UpdateCurrentUser)
 
 		// Personas (accesible para usuarios autenticados)
@@ -43,15 +48,55 @@ func AdminRutas(r *gin.Engine) {
 			medico.GET("", controllers.GetAllMedicos)
 			medico.GET("/:id", controllers.GetMedico)
 			medico.GET("/:id/horarios", controllers.GetHorariosPorMedico)
+			medico.GET("/:id/disponibilidad", controllers.GetDisponibilidadMedico)
+			medico.GET("/:id/politica-cancelacion", controllers.GetPoliticaCancelacionMedico)
+
+			// Sala de espera (recepción y el propio médico)
+			medico.GET("/:id/cola", controllers.GetColaMedico)
+			medico.PUT("/:id/cola/siguiente", controllers.LlamarSiguientePaciente)
+
+			// Ausencias y excepciones de agenda (personal de la clínica y el propio médico)
+			medico.GET("/:id/bloqueos", controllers.GetBloqueosAgenda)
+			medico.POST("/:id/bloqueos", controllers.PostBloqueoAgenda)
+			medico.DELETE("/:id/bloqueos/:bloqueo_id", controllers.DeleteBloqueoAgenda)
 		}
 
+		// Calendario de días festivos de la clínica
+		protected.GET("/dias-festivos", controllers.GetDiasFestivos)
+
+		// Catálogo de tipos de cita
+		protected.GET("/tipos-cita", controllers.GetAllTiposCita)
+		protected.GET("/tipos-cita/:id", controllers.GetTipoCita)
+
 		// Citas (accesible para pacientes y médicos)
 		cita := protected.Group("/citas")
 		{
 			cita.POST("", controllers.PostCita)
 			cita.GET("", controllers.GetCitasUsuarioActual) // Devuelve citas según rol
+			cita.GET("/limites", controllers.GetLimitesCitas)
 			cita.GET("/:id", controllers.GetCita)
-			cita.PUT("/:id/cancelar", controllers.CancelarCita)
+			cita.GET("/:id/sala-virtual", controllers.GetSalaVirtualCita) // Solo paciente y médico de la cita
+			cita.GET("/:id/ics", controllers.GetCitaICS)
+			cita.PUT("/:id/cancelar", controllers.CancelarCita) // ?alcance=esta|siguientes|serie, {"motivo"} obligatorio para médicos
+			cita.PUT("/:id/reprogramar", controllers.ReprogramarCita)
+			cita.PUT("/:id/estado", controllers.CambiarEstadoCita)
+			cita.PUT("/:id/llegada", controllers.RegistrarLlegadaCita) // Recepción
+			cita.PUT("/:id/finalizar", controllers.FinalizarConsulta)  // Médico de la cita
+
+			// Series de citas recurrentes
+			cita.POST("/series", controllers.PostSerieCita)
+			cita.GET("/series/:id", controllers.GetSerieCita)
+			cita.PUT("/:id/serie/reprogramar", controllers.ReprogramarSerieCita)
+		}
+
+		// Lista de espera
+		listaEspera := protected.Group("/lista-espera")
+		{
+			listaEspera.POST("", controllers.PostListaEspera)
+			listaEspera.GET("", controllers.GetListaEspera)
+			listaEspera.PUT("/:id/aceptar", controllers.AceptarOfertaListaEspera)
+			listaEspera.PUT("/:id/rechazar", controllers.RechazarOfertaListaEspera)
+			listaEspera.DELETE("/:id", controllers.CancelarListaEspera)
 		}
 
 		// Observaciones (accesible para médicos y pacientes)
@@ -60,14 +105,33 @@ func AdminRutas(r *gin.Engine) {
 			observacion.GET("/cita/:cita_id", controllers.GetObservacionPorCita)
 		}
 
-		// Notificaciones
-		// notificacion := protected.Group("/notificaciones")
-		// {
-		// 	notificacion.GET("", controllers.GetNotificacionesUsuarioActual)
-		// 	notificacion.PUT("/:id/marcar-leida", controllers.MarcarNotificacionLeida)
-		// }
+		// Agenda por día, semana o mes (recepción, administración y el propio médico)
+		protected.GET("/agenda", controllers.GetAgenda)
+
+		// Suscripción de calendario externo
+		calendario := protected.Group("/calendario")
+		{
+			calendario.GET("/suscripcion", controllers.GetSuscripcionCalendario)
+			calendario.POST("/suscripcion/rotar", controllers.RotarSuscripcionCalendario)
+		}
+
+		// Bandeja de notificaciones del usuario actual
+		notificacion := protected.Group("/notificaciones")
+		{
+			notificacion.GET("", controllers.GetNotificacionesUsuarioActual) // ?tipo=&leida=false&archivadas=true
+			notificacion.GET("/no-leidas", controllers.GetNotificacionesNoLeidas)
+			notificacion.PUT("/marcar-leidas", controllers.MarcarNotificacionesLeidas) // ?tipo= opcional
+			notificacion.PUT("/:id/marcar-leida", controllers.MarcarNotificacionLeida)
+			notificacion.PUT("/:id/marcar-no-leida", controllers.MarcarNotificacionNoLeida)
+			notificacion.PUT("/:id/archivar", controllers.ArchivarNotificacion)
+			notificacion.PUT("/:id/desarchivar", controllers.DesarchivarNotificacion)
+		}
 	}
 
+	// Eventos en tiempo real (Server-Sent Events); sin encabezado Authorization se abre con ?ticket=
+	r.POST("/api/stream/ticket", middlewares.AuthMiddleware(), controllers.PostTicketStream)
+	r.GET("/api/stream", middlewares.AuthStream(), controllers.GetStream)
+
 	// ================== RUTAS DE ADMINISTRADOR ==================
 	admin := r.Group("/api/admin")
 	admin.Use(middlewares.AuthMiddleware(), middlewares.AdminOnly())
@@ -75,12 +139,42 @@ func AdminRutas(r *gin.Engine) {
 		// Gestión completa de personas
 		admin.DELETE("/personas/:id", controllers.DeletePersona)
 
+		// Gestión de usuarios (alta de personal como recepcionistas)
+		admin.GET("/usuarios", controllers.GetAllUsuarios)
+		admin.POST("/usuarios", controllers.PostUsuario)
+		admin.PUT("/usuarios/:id", controllers.UpdateUsuario)
+
 		// Gestión completa de médicos
 		admin.POST("/medicos", controllers.PostMedico)
 		admin.PUT("/medicos/:id", controllers.UpdateMedico)
 		admin.DELETE("/medicos/:id", controllers.DeleteMedico)
+		admin.POST("/medicos/:id/reasignar-citas", controllers.ReasignarCitasMedico) // Cancelar o reprogramar por ausencia
+
+		// Configuración de agenda por especialidad
+		admin.GET("/especialidades", controllers.GetAllConfiguracionesEspecialidad)
+		admin.POST("/especialidades", controllers.PostConfiguracionEspecialidad)
+		admin.PUT("/especialidades/:id", controllers.UpdateConfiguracionEspecialidad)
+		admin.DELETE("/especialidades/:id", controllers.DeleteConfiguracionEspecialidad)
+
+		// Catálogo de tipos de cita
+		admin.POST("/tipos-cita", controllers.PostTipoCita)
+		admin.PUT("/tipos-cita/:id", controllers.UpdateTipoCita)
+		admin.DELETE("/tipos-cita/:id", controllers.DeleteTipoCita)
+
+		// Políticas de cancelación (clínica, especialidad o médico)
+		admin.GET("/politicas-cancelacion", controllers.GetAllPoliticasCancelacion)
+		admin.POST("/politicas-cancelacion", controllers.PostPoliticaCancelacion)
+		admin.PUT("/politicas-cancelacion/:id", controllers.UpdatePoliticaCancelacion)
+		admin.DELETE("/politicas-cancelacion/:id", controllers.DeletePoliticaCancelacion)
+
+		// Calendario de días festivos
+		admin.POST("/dias-festivos", controllers.PostDiaFestivo)
+		admin.POST("/dias-festivos/importar", controllers.ImportarDiasFestivos) // Archivo JSON o CSV
+		admin.PUT("/dias-festivos/:id", controllers.UpdateDiaFestivo)
+		admin.DELETE("/dias-festivos/:id", controllers.DeleteDiaFestivo)
 
 		// Gestión de horarios médicos
+		admin.GET("/horarios", controllers.GetAllHorarios)
 		admin.POST("/medicos/:id/horarios", controllers.PostHorario)
 		admin.PUT("/horarios/:id", controllers.UpdateHorario)
 		admin.DELETE("/horarios/:id", controllers.DeleteHorario)
@@ -88,7 +182,11 @@ func AdminRutas(r *gin.Engine) {
 		// Gestión completa de citas
 		admin.PUT("/citas/:id", controllers.UpdateCita)
 		admin.DELETE("/citas/:id", controllers.DeleteCita)
-		admin.GET("/citas/todas", controllers.GetAllCitas)
+		admin.GET("/citas/todas", controllers.GetAllCitas) // ?estado=por_aprobar para las pendientes de aprobación
+		admin.PUT("/citas/:id/aprobar", controllers.AprobarCita)
+		admin.POST("/citas/inasistencias", controllers.MarcarInasistencias)
+		admin.POST("/citas/recordatorios", controllers.EnviarRecordatorios)
+		admin.GET("/usuarios/:id/inasistencias", controllers.GetInasistenciasPaciente)
 
 		// Gestión de observaciones
 		admin.POST("/observaciones", controllers.PostObservacion)
@@ -97,6 +195,12 @@ func AdminRutas(r *gin.Engine) {
 
 		// Gestión de notificaciones
 		admin.POST("/notificaciones", controllers.PostNotificacion)
+		admin.GET("/notificaciones/:id/entregas", controllers.GetEntregasNotificacion)
+		admin.GET("/entregas", controllers.GetAllEntregas) // ?estado=fallida&canal=sms
+		admin.GET("/outbox", controllers.GetAllEventosOutbox) // ?estado=fallido&tipo=entrega.enviar
+		admin.POST("/outbox/reintentar", controllers.ReintentarEventosFallidos)
+		admin.GET("/outbox/:id", controllers.GetEventoOutbox)
+		admin.POST("/outbox/:id/reintentar", controllers.ReintentarEventoOutbox)
 		// admin.GET("/notificaciones/todas", controllers.GetAllNotificaciones)
 		admin.DELETE("/notificaciones/:id", controllers.DeleteNotificacion)
 
@@ -0,0 +1,71 @@
+package videollamada
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Ilimm9/CMedicas/models"
+)
+
+// Proveedor genera la sala de videollamada de una cita
+type Proveedor interface {
+	CrearSala(cita models.Cita) (string, error)
+}
+
+// ProveedorLocal genera enlaces deterministas a partir del ID de la cita, firmados con una clave
+// para que no se puedan adivinar. Sirve sin servicios externos y en pruebas.
+type ProveedorLocal struct {
+	URLBase string
+	Clave   []byte
+}
+
+// ErrSinClave indica que no se configuró la clave para firmar los enlaces
+var ErrSinClave = errors.New("VIDEOLLAMADA_CLAVE no está configurada")
+
+// CrearSala devuelve siempre el mismo enlace para la misma cita
+func (p ProveedorLocal) CrearSala(cita models.Cita) (string, error) {
+	if len(p.Clave) == 0 {
+		return "", ErrSinClave
+	}
+	mac := hmac.New(sha256.New, p.Clave)
+	fmt.Fprintf(mac, "cita:%d", cita.ID)
+	firma := hex.EncodeToString(mac.Sum(nil))[:20]
+	return fmt.Sprintf("%s/cita-%d-%s", strings.TrimRight(p.URLBase, "/"), cita.ID, firma), nil
+}
+
+var (
+	mu     sync.RWMutex
+	actual Proveedor
+)
+
+// Configurar reemplaza el proveedor en uso (por ejemplo, con uno falso en pruebas)
+func Configurar(p Proveedor) {
+	mu.Lock()
+	defer mu.Unlock()
+	actual = p
+}
+
+// Actual devuelve el proveedor configurado. Por defecto usa el local con VIDEOLLAMADA_URL_BASE
+// y la clave VIDEOLLAMADA_CLAVE; sin clave no se pueden crear salas.
+func Actual() Proveedor {
+	mu.RLock()
+	p := actual
+	mu.RUnlock()
+	if p != nil {
+		return p
+	}
+
+	urlBase := os.Getenv("VIDEOLLAMADA_URL_BASE")
+	if urlBase == "" {
+		urlBase = "https://videollamada.cmedicas.local"
+	}
+
+	Configurar(ProveedorLocal{URLBase: urlBase, Clave: []byte(os.Getenv("VIDEOLLAMADA_CLAVE"))})
+	return Actual()
+}
@@ -0,0 +1,44 @@
+package videollamada
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Ilimm9/CMedicas/models"
+)
+
+func TestProveedorLocalDeterminista(t *testing.T) {
+	p := ProveedorLocal{URLBase: "https://salas.ejemplo/", Clave: []byte("clave")}
+
+	cita := models.Cita{ID: 42}
+	primera, err := p.CrearSala(cita)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segunda, err := p.CrearSala(cita)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if primera != segunda {
+		t.Errorf("la misma cita dio enlaces distintos: %s y %s", primera, segunda)
+	}
+	if !strings.HasPrefix(primera, "https://salas.ejemplo/cita-42-") {
+		t.Errorf("enlace inesperado: %s", primera)
+	}
+
+	otra, _ := p.CrearSala(models.Cita{ID: 43})
+	if otra == primera {
+		t.Error("dos citas comparten enlace")
+	}
+
+	otraClave, _ := ProveedorLocal{URLBase: p.URLBase, Clave: []byte("otra")}.CrearSala(cita)
+	if otraClave == primera {
+		t.Error("el enlace no depende de la clave")
+	}
+}
+
+func TestProveedorLocalSinClave(t *testing.T) {
+	if _, err := (ProveedorLocal{URLBase: "https://salas.ejemplo"}).CrearSala(models.Cita{ID: 1}); err != ErrSinClave {
+		t.Errorf("se esperaba ErrSinClave, se obtuvo %v", err)
+	}
+}